@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Check looks for the problems that do not prevent c from being read:
+// missing directories and schedules that make no sense.
+func (c *Config) Check() ErrorList {
+	var errs ErrorList
+	errorf := func(format string, a ...interface{}) {
+		errs = append(errs, &Error{File: c.Path(), Err: fmt.Errorf(format, a...)})
+	}
+	dir := func(what, d string) {
+		if d == "" {
+			errorf("no %v directory", what)
+		} else if fi, err := os.Stat(d); err != nil {
+			errorf("%v directory: %v", what, err)
+		} else if !fi.IsDir() {
+			errorf("%v directory: %v is not a directory", what, d)
+		}
+	}
+	dir("data", c.DataDir())
+	dir("static", c.StaticDir())
+	if d := c.FillerDir(); d != "" {
+		dir("filler", d)
+	}
+	for _, k := range Interstitials {
+		if cl, ok := c.Interstitial(k); ok {
+			dir(k, cl.Dir)
+		}
+	}
+	for _, f := range []string{c.IndexFile(), c.StateFile(), c.HistoryFile(), c.CacheDir()} {
+		if _, err := os.Stat(filepath.Dir(f)); err != nil {
+			errorf("cannot write %v: %v", f, err)
+		}
+	}
+
+	for _, s := range c.Slots() {
+		if s.each != 0 && s.duration > s.each {
+			errorf("duration %v is longer than each %v", s.duration, s.each)
+		}
+		if s.each == 0 && s.duration > 24*time.Hour {
+			errorf("slot %v: duration %v is longer than a day", s.Name, s.duration)
+		}
+	}
+	// two weeks of windows, to see every pair of slots
+	var ws []Window
+	now := time.Now().In(c.Location())
+	for _, s := range c.Slots() {
+		for t := now; t.Before(now.Add(14 * 24 * time.Hour)); {
+			w := s.next(t)
+			if w.IsZero() {
+				break
+			}
+			ws = append(ws, w)
+			t = w.End
+		}
+	}
+	seen := make(map[[2]string]bool)
+	for i, a := range ws {
+		for _, b := range ws[i+1:] {
+			k := [2]string{a.Slot, b.Slot}
+			if a.Slot == b.Slot || seen[k] {
+				continue
+			}
+			if a.Start.Before(b.End) && b.Start.Before(a.End) {
+				seen[k] = true
+				errorf("slots %v and %v overlap on %v", a.Slot, b.Slot,
+					a.Start.In(c.Location()).Format("Mon 2006-01-02"))
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,42 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Interstitial is a kind of clips aired around the episodes: a station
+// ident at the start (and every Period if set), a bumper every Every
+// breaks between episodes, or a sign-off at the end.
+type Interstitial struct {
+	Kind   string
+	Dir    string
+	Every  int
+	Period time.Duration
+}
+
+// Kinds of interstitials.
+var Interstitials = []string{"ident", "bumper", "signoff"}
+
+// setRule parses `every <n>` for bumpers and `every <duration>` for idents.
+func (cl *Interstitial) setRule(args []string) error {
+	if len(args) != 2 || args[0] != "every" || cl.Kind == "signoff" {
+		return fmt.Errorf("unknown rule '%v'", strings.Join(args, " "))
+	}
+	if cl.Kind == "bumper" {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("'every' expects a positive number")
+		}
+		cl.Every = n
+		return nil
+	}
+	d, err := time.ParseDuration(args[1])
+	if err != nil || d <= 0 {
+		return fmt.Errorf("'every' expects a positive duration")
+	}
+	cl.Period = d
+	return nil
+}
@@ -1,29 +1,50 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
-	"strings"
+	"runtime"
+	"sync"
 	"time"
 )
 
 type Config struct {
-	path      string
-	each      time.Duration
-	start     time.Time
-	duration  time.Duration
-	dataDir   string
-	staticDir string
-	ignore    map[string]struct{}
+	mu           sync.RWMutex
+	path         string
+	doc          []byte
+	slots        []*Slot
+	legacy       bool
+	dataDir      string
+	staticDir    string
+	indexFile    string
+	stateFile    string
+	order        string
+	fillerDir    string
+	clips        map[string]Interstitial
+	historyFile  string
+	noRepeat     time.Duration
+	retention    time.Duration
+	seed         int64
+	seeded       bool
+	probeWorkers int
+	jobs         int
+	threads      int
+	buffer       time.Duration
+	buffered     bool
+	cacheDir     string
+	cacheSize    int64
+	loc          *time.Location
+	ignore       []*Pattern
 }
 
 func Open(path string) (*Config, error) {
-	fmt.Println("searching for config file", path)
+	log.Println("searching for config file", path)
 
 	if _, err := os.Stat(path); os.IsNotExist(err) {
-		fmt.Println("config file does not exist, creating a new one...")
+		log.Println("config file does not exist, creating a new one...")
 		_, err := os.Create(path)
 		if err != nil {
 			return nil, err
@@ -35,158 +56,380 @@ func Open(path string) (*Config, error) {
 		staticDir := filepath.Join(filepath.Dir(path), "static")
 		config := &Config{
 			path:      path,
-			each:      each,
-			start:     start,
-			duration:  duration,
+			slots:     []*Slot{newLegacySlot(start, each, duration)},
+			legacy:    true,
 			dataDir:   dataDir,
 			staticDir: staticDir,
-			ignore:    make(map[string]struct{}),
+			loc:       time.Local,
 		}
-		config.Write()
-		return config, nil
+		config.doc = config.generate()
+		return config, config.Write()
 	} else {
-		c := &Config{
-			path:   path,
-			ignore: make(map[string]struct{}),
-		}
-		return c.read(path)
+		return read(path)
 	}
 }
 
-func (c *Config) read(path string) (*Config, error) {
+// Load reads the config file at path, unlike Open it does not create
+// it if it does not exist.
+func Load(path string) (*Config, error) {
+	return read(path)
+}
+
+func read(path string) (*Config, error) {
 	str, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	lines := strings.Split(string(str), "\n")
-	for _, l := range lines {
-		words := strings.Fields(l)
-		if len(words) < 1 {
+	return parse(path, str)
+}
+
+// generate returns a fresh config file describing c.
+func (c *Config) generate() []byte {
+	if isJSON(c.path, nil) {
+		return c.generateJSON()
+	}
+	str := ""
+	slots := c.slots
+	if c.legacy {
+		s := slots[0]
+		str += "start " + s.Start() + "\n"
+		str += fmt.Sprintln("each", s.Each())
+		str += fmt.Sprintln("duration", s.duration)
+		slots = slots[1:]
+	}
+	for _, s := range slots {
+		str += fmt.Sprintln("slot", s)
+	}
+	if c.loc != nil && c.loc != time.Local {
+		str += fmt.Sprintln("timezone", c.loc)
+	}
+	str += fmt.Sprintln("data", c.dataDir)
+	str += fmt.Sprintln("static", c.staticDir)
+	return []byte(str)
+}
+
+func (c *Config) generateJSON() []byte {
+	var jc jsonConfig
+	slots := c.slots
+	if c.legacy {
+		s := slots[0]
+		jc.Start = s.Start()
+		jc.Each = s.Each().String()
+		jc.Duration = s.duration.String()
+		slots = slots[1:]
+	}
+	for _, s := range slots {
+		jc.Slots = append(jc.Slots, jsonSlot{
+			Name:     s.Name,
+			Days:     s.Days(),
+			Start:    s.Start(),
+			Duration: s.duration.String(),
+			Order:    s.order,
+		})
+	}
+	if c.loc != nil && c.loc != time.Local {
+		jc.Timezone = c.loc.String()
+	}
+	jc.Data = c.dataDir
+	jc.Static = c.staticDir
+	str, _ := json.MarshalIndent(jc, "", "  ")
+	return append(str, '\n')
+}
+
+// String returns the config file, as it will be written.
+func (c *Config) String() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return string(c.doc)
+}
+
+func (c *Config) Write() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return os.WriteFile(c.path, c.doc, 0666)
+}
+
+// Update re-reads the config file. If the file is invalid, the errors
+// are returned and c is left untouched.
+func (c *Config) Update() error {
+	n, err := read(c.path)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.set(n)
+	return nil
+}
+
+func (c *Config) set(n *Config) {
+	c.doc = n.doc
+	c.slots = n.slots
+	c.legacy = n.legacy
+	c.dataDir = n.dataDir
+	c.staticDir = n.staticDir
+	c.indexFile = n.indexFile
+	c.stateFile = n.stateFile
+	c.order = n.order
+	c.fillerDir = n.fillerDir
+	c.clips = n.clips
+	c.historyFile = n.historyFile
+	c.noRepeat = n.noRepeat
+	c.retention = n.retention
+	c.seed, c.seeded = n.seed, n.seeded
+	c.probeWorkers = n.probeWorkers
+	c.jobs, c.threads = n.jobs, n.threads
+	c.buffer, c.buffered = n.buffer, n.buffered
+	c.cacheDir = n.cacheDir
+	c.cacheSize = n.cacheSize
+	c.loc = n.loc
+	c.ignore = n.ignore
+}
+
+// NextWindow returns the broadcast window that is running at `now`
+// or, if there is none, the one that starts the soonest. The window
+// is expressed in the configured time zone.
+func (c *Config) NextWindow(now time.Time) Window {
+	var next Window
+	now = now.In(c.Location())
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, s := range c.slots {
+		w := s.next(now)
+		if w.IsZero() {
 			continue
 		}
-		switch words[0] {
-		case "start":
-			check(&words, "start")
-			c.start, err = time.Parse(time.Kitchen, words[1])
-			if err != nil {
-				return nil, err
-			}
-		case "each":
-			check(&words, "each")
-			c.each, err = time.ParseDuration(words[1])
-			if err != nil {
-				return nil, err
-			}
-		case "duration":
-			check(&words, "duration")
-			c.duration, err = time.ParseDuration(words[1])
-			if err != nil {
-				return nil, err
-			}
-		case "data":
-			check(&words, "data")
-			if filepath.IsAbs(words[1]) {
-				c.dataDir = words[1]
-			} else {
-				c.dataDir = filepath.Join(filepath.Dir(path), words[1])
-			}
-		case "static":
-			check(&words, "static")
-			if filepath.IsAbs(words[1]) {
-				c.staticDir = words[1]
-			} else {
-				c.staticDir = filepath.Join(filepath.Dir(path), words[1])
-			}
-		case "skip":
-			check(&words, "skip")
-			fallthrough
-		case "ignore":
-			f := ""
-			for _, w := range words[1:] {
-				if w == "#" {
-					break
-				} else if f != "" {
-					f += " " + w
-				} else {
-					f = w
-				}
-			}
-			if f == "" {
-				continue
-			}
-			if strings.HasPrefix(f, "\"") && strings.HasSuffix(f, "\"") {
-				f = strings.Trim(f, "\"")
-			}
-			if !filepath.IsAbs(f) {
-				if c.dataDir != "" {
-					f = filepath.Join(c.dataDir, f)
-				} else {
-					// `dataDirstatic` is not defined yet
-					// the path is relative to config location
-					f = filepath.Join(filepath.Dir(path), f)
-				}
-			}
-			c.ignore[f] = struct{}{}
-			log.Println("config: ignore", f)
+		if next.IsZero() || w.Start.Before(next.Start) {
+			next = w
 		}
 	}
-	return c, nil
+	return next
 }
 
-func (c *Config) String() string {
-	str := "start " + c.start.Format(time.Kitchen) + "\n"
-	str += fmt.Sprintln("each", c.each)
-	str += fmt.Sprintln("duration", c.duration)
-	str += fmt.Sprintln("data", c.dataDir)
-	str += fmt.Sprintln("static", c.staticDir)
-	return str
+// Windows returns the n windows following `now`, the running one included.
+func (c *Config) Windows(now time.Time, n int) []Window {
+	ws := make([]Window, 0, n)
+	for len(ws) < n {
+		w := c.NextWindow(now)
+		if w.IsZero() {
+			break
+		}
+		ws = append(ws, w)
+		now = w.End
+	}
+	return ws
 }
 
-func (c *Config) Write() {
-	os.WriteFile(c.path, []byte(c.String()), 0666)
+// Location returns the time zone of the schedule.
+func (c *Config) Location() *time.Location {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.loc == nil {
+		return time.Local
+	}
+	return c.loc
 }
 
-func (c *Config) Update() error {
-	_, err := c.read(c.path)
-	return err
+func (c *Config) Slots() []*Slot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.slots
 }
 
 func (c *Config) ReadyToPlay() (bool, time.Duration, time.Duration) {
-	now := time.Now()
-	start := time.Date(now.Year(), now.Month(), now.Day(),
-		c.start.Hour(), c.start.Minute(), c.start.Second(),
-		c.start.Nanosecond(), now.Location())
-
-	ok := start.Before(now) && start.Add(c.duration).After(now)
-	if !ok {
-		if now.Before(start) {
-			return ok, start.Sub(now), c.duration
-		} else {
-			return ok, start.Add(c.each).Sub(now), c.duration
-		}
+	now := time.Now().In(c.Location())
+	w := c.NextWindow(now)
+	if !w.Contains(now) {
+		return false, w.Start.Sub(now), w.Duration()
 	}
-	return ok, time.Duration(0), start.Add(c.duration).Sub(now)
+	return true, time.Duration(0), w.End.Sub(now)
 }
 
+// Duration returns the length of the next broadcast window.
 func (c *Config) Duration() time.Duration {
-	return c.duration
+	return c.NextWindow(time.Now()).Duration()
+}
+
+func (c *Config) Path() string {
+	return c.path
 }
 
 func (c *Config) DataDir() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.dataDir
 }
 
 func (c *Config) StaticDir() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return c.staticDir
 }
 
-func (c *Config) Ignore(f string) bool {
-	_, exist := c.ignore[f]
-	return exist
+// IndexFile returns the path of the media library index.
+func (c *Config) IndexFile() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.indexFile == "" {
+		return filepath.Join(filepath.Dir(c.path), "smc.index")
+	}
+	return c.indexFile
 }
 
-func check(line *[]string, attr string) {
-	if len(*line) < 2 {
-		log.Fatal("invalid config file: '" + attr + "' lacks argument")
+// StateFile returns the path of the file where the station keeps
+// track of the aired episodes.
+func (c *Config) StateFile() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.stateFile == "" {
+		return filepath.Join(filepath.Dir(c.path), "smc.state")
 	}
+	return c.stateFile
+}
+
+// Order returns the default scheduling strategy of the station.
+func (c *Config) Order() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.order == "" {
+		return "shuffle"
+	}
+	return c.order
+}
+
+// FillerDir returns the directory of the clips that fill the end of
+// the programs, if any.
+func (c *Config) FillerDir() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.fillerDir
+}
+
+// Interstitial returns the clips of the given kind (ident, bumper or
+// signoff), if any.
+func (c *Config) Interstitial(kind string) (Interstitial, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	cl, ok := c.clips[kind]
+	return cl, ok
+}
+
+// HistoryFile returns the path of the log of the aired episodes.
+func (c *Config) HistoryFile() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.historyFile == "" {
+		return filepath.Join(filepath.Dir(c.path), "smc.history")
+	}
+	return c.historyFile
+}
+
+// No-repeat policies, see NoRepeat.
+const (
+	// Exhaust means that nothing airs twice before the whole library
+	// has been aired.
+	Exhaust time.Duration = 0
+	// Repeat means that anything may air again.
+	Repeat time.Duration = -1
+)
+
+// NoRepeat returns how long an episode cannot air again after it has
+// been aired, or one of Exhaust (the default) and Repeat.
+func (c *Config) NoRepeat() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.noRepeat
+}
+
+// Forever is the history retention that never drops any airing, see
+// HistoryRetention.
+const Forever time.Duration = -1
+
+// HistoryRetention returns how long the airings are kept in the history
+// beyond what the no-repeat policy needs, or Forever. It is 0 by
+// default: with no-repeat off, the history is then never compacted.
+func (c *Config) HistoryRetention() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.retention
+}
+
+// Seed returns the seed of the schedule, if any: the same config and
+// library then always give the same programs.
+func (c *Config) Seed() (int64, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.seed, c.seeded
+}
+
+// OrderOf returns the scheduling strategy of w.
+func (c *Config) OrderOf(w Window) string {
+	if w.Order != "" {
+		return w.Order
+	}
+	return c.Order()
+}
+
+// ProbeWorkers returns the number of files probed in parallel.
+func (c *Config) ProbeWorkers() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.probeWorkers == 0 {
+		return runtime.NumCPU()
+	}
+	return c.probeWorkers
+}
+
+// TranscodeJobs returns the number of files transcoded in parallel.
+func (c *Config) TranscodeJobs() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.jobs == 0 {
+		return 1
+	}
+	return c.jobs
+}
+
+// TranscodeThreads returns the number of threads of each transcoding,
+// 0 lets ffmpeg decide.
+func (c *Config) TranscodeThreads() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.threads
+}
+
+// Buffer returns how much of a program, beyond its first episode, is
+// transcoded before it airs (5m by default).
+func (c *Config) Buffer() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.buffered {
+		return 5 * time.Minute
+	}
+	return c.buffer
+}
+
+// CacheDir returns where the transcoded files are kept.
+func (c *Config) CacheDir() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.cacheDir == "" {
+		return filepath.Join(filepath.Dir(c.path), "smc.cache")
+	}
+	return c.cacheDir
+}
+
+// CacheSize returns how many bytes the cache may take, 0 (the default)
+// disables it.
+func (c *Config) CacheSize() int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cacheSize
+}
+
+// Ignore reports whether f matches one of the `ignore` patterns.
+func (c *Config) Ignore(f string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return MatchAny(c.ignore, f)
 }
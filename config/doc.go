@@ -0,0 +1,282 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The config file is kept as it was read so that programmatic edits
+// only touch the modified values and leave comments, ordering and
+// formatting alone.
+
+type span struct {
+	start int
+	end   int
+}
+
+// Set sets the single-valued directive `key` (start, each, duration,
+// timezone, data, static, index, state, order, filler, history,
+// no-repeat, history-retention, seed, probe-workers, transcode-jobs,
+// transcode-threads, buffer, cache or cache-size) and applies it to c. The file is not
+// written until Write is called. If the result is invalid, c is left
+// untouched and the errors are returned.
+func (c *Config) Set(key, value string) error {
+	switch key {
+	case "start", "each", "duration", "timezone", "data", "static",
+		"index", "state", "order", "filler", "history", "no-repeat",
+		"history-retention", "seed", "probe-workers", "transcode-jobs",
+		"transcode-threads", "buffer", "cache", "cache-size":
+	default:
+		return fmt.Errorf("config: cannot set '%v'", key)
+	}
+	return c.edit(func(doc []byte) []byte {
+		if isJSON(c.path, doc) {
+			if n, err := strconv.Atoi(value); err == nil && isNumber(key) {
+				return jsonSet(doc, key, n)
+			}
+			return jsonSet(doc, key, value)
+		}
+		return legacySet(doc, key, func(args []Field) bool {
+			return true
+		}, Quote(value))
+	})
+}
+
+// isNumber reports whether the value of key is written as a number in
+// JSON files.
+func isNumber(key string) bool {
+	switch key {
+	case "seed", "probe-workers", "transcode-jobs", "transcode-threads":
+		return true
+	}
+	return false
+}
+
+// SetSlot adds the slot `name` or replaces it if it already exists.
+func (c *Config) SetSlot(name, days, start string, duration time.Duration) error {
+	if _, err := NewSlot(name, days, start, duration); err != nil {
+		return err
+	}
+	return c.edit(func(doc []byte) []byte {
+		if isJSON(c.path, doc) {
+			return jsonSetElem(doc, "slots", func(raw []byte) bool {
+				var s jsonSlot
+				return json.Unmarshal(raw, &s) == nil && s.Name == name
+			}, jsonSlot{name, days, start, duration.String(), ""})
+		}
+		return legacySet(doc, "slot", func(args []Field) bool {
+			return len(args) > 0 && args[0].Value == name
+		}, fmt.Sprintf("%v %v %v %v", Quote(name), days, start, duration))
+	})
+}
+
+// AddIgnore adds f to the list of ignored files.
+func (c *Config) AddIgnore(f string) error {
+	return c.edit(func(doc []byte) []byte {
+		if isJSON(c.path, doc) {
+			return jsonSetElem(doc, "ignore", func([]byte) bool {
+				return false
+			}, f)
+		}
+		return legacySet(doc, "ignore", func([]Field) bool {
+			return false
+		}, Quote(f))
+	})
+}
+
+func (c *Config) edit(f func([]byte) []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	doc := f(c.doc)
+	n, err := parse(c.path, doc)
+	if err != nil {
+		return err
+	}
+	c.set(n)
+	return nil
+}
+
+func legacyFields(l string) []Field {
+	fs, _ := SplitFields(l)
+	return fs
+}
+
+// legacySet replaces the arguments of the last `key` line whose
+// arguments match, or, if there is none, adds a new line after the
+// last `key` line (or at the end of the file).
+func legacySet(doc []byte, key string, match func([]Field) bool, value string) []byte {
+	lines := strings.Split(string(doc), "\n")
+	found, last := -1, -1
+	for i, l := range lines {
+		ws := legacyFields(l)
+		if len(ws) == 0 || ws[0].Value != key {
+			continue
+		}
+		last = i
+		if match(ws[1:]) {
+			found = i
+		}
+	}
+
+	if found >= 0 {
+		l := lines[found]
+		ws := legacyFields(l)
+		if len(ws) < 2 {
+			lines[found] = strings.TrimRight(l[:ws[0].End], " \t") + " " + value + l[ws[0].End:]
+		} else {
+			a := ws[1].Start
+			b := ws[len(ws)-1].End
+			rest := l[b:]
+			// keep the comments aligned
+			if pad := (b - a) - len(value); pad > 0 && strings.Contains(rest, "#") {
+				rest = strings.Repeat(" ", pad) + rest
+			} else if pad < 0 && strings.Contains(rest, "#") {
+				comment := strings.TrimLeft(rest, " ")
+				if spaces := len(rest) - len(comment); spaces+pad > 0 {
+					rest = rest[-pad:]
+				} else {
+					rest = " " + comment
+				}
+			}
+			lines[found] = l[:a] + value + rest
+		}
+		return []byte(strings.Join(lines, "\n"))
+	}
+
+	l := key + " " + value
+	if last >= 0 {
+		// align with the previous line
+		ws := legacyFields(lines[last])
+		if len(ws) > 1 {
+			l = lines[last][:ws[1].Start] + value
+		}
+		lines = append(lines[:last+1], append([]string{l}, lines[last+1:]...)...)
+		return []byte(strings.Join(lines, "\n"))
+	}
+	if n := len(lines); n > 0 && lines[n-1] == "" {
+		lines[n-1] = l
+		return []byte(strings.Join(lines, "\n") + "\n")
+	}
+	return []byte(strings.Join(append(lines, l), "\n") + "\n")
+}
+
+// jsonKeys returns the spans of the top-level values of doc and the
+// offset of its closing brace.
+func jsonKeys(doc []byte) (map[string]span, int) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	keys := make(map[string]span)
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return keys, -1
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return keys, -1
+		}
+		start := skipSpace(doc, int(dec.InputOffset()))
+		var raw json.RawMessage
+		if err = dec.Decode(&raw); err != nil {
+			return keys, -1
+		}
+		keys[t.(string)] = span{start, int(dec.InputOffset())}
+	}
+	if _, err := dec.Token(); err != nil {
+		return keys, -1
+	}
+	return keys, int(dec.InputOffset()) - 1
+}
+
+func jsonElems(raw []byte) []span {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	var es []span
+	if t, err := dec.Token(); err != nil || t != json.Delim('[') {
+		return nil
+	}
+	for dec.More() {
+		start := skipSpace(raw, int(dec.InputOffset()))
+		var e json.RawMessage
+		if err := dec.Decode(&e); err != nil {
+			return nil
+		}
+		es = append(es, span{start, int(dec.InputOffset())})
+	}
+	return es
+}
+
+func skipSpace(doc []byte, off int) int {
+	for off < len(doc) && strings.IndexByte(" \t\r\n,:", doc[off]) >= 0 {
+		off++
+	}
+	return off
+}
+
+// indent returns the indentation of the line containing off.
+func indent(doc []byte, off int) string {
+	l := bytes.LastIndexByte(doc[:off], '\n') + 1
+	i := l
+	for i < off && (doc[i] == ' ' || doc[i] == '\t') {
+		i++
+	}
+	return string(doc[l:i])
+}
+
+func splice(doc []byte, s span, v string) []byte {
+	n := make([]byte, 0, len(doc)+len(v))
+	n = append(n, doc[:s.start]...)
+	n = append(n, v...)
+	return append(n, doc[s.end:]...)
+}
+
+func jsonSet(doc []byte, key string, value interface{}) []byte {
+	v, _ := json.Marshal(value)
+	keys, end := jsonKeys(doc)
+	if s, ok := keys[key]; ok {
+		return splice(doc, s, string(v))
+	}
+	if end < 0 {
+		return doc
+	}
+	ind := "  "
+	last := -1
+	for _, s := range keys {
+		if s.end > last {
+			last = s.end
+			ind = indent(doc, s.start)
+		}
+	}
+	k, _ := json.Marshal(key)
+	if last < 0 {
+		return splice(doc, span{end, end}, "\n"+ind+string(k)+": "+string(v)+"\n")
+	}
+	return splice(doc, span{last, last}, ",\n"+ind+string(k)+": "+string(v))
+}
+
+// jsonSetElem replaces the first element of the top-level array `key`
+// matching `match` by value, or appends value to the array.
+func jsonSetElem(doc []byte, key string, match func([]byte) bool, value interface{}) []byte {
+	keys, _ := jsonKeys(doc)
+	s, ok := keys[key]
+	if !ok {
+		return jsonSet(doc, key, []interface{}{value})
+	}
+	v, _ := json.Marshal(value)
+	es := jsonElems(doc[s.start:s.end])
+	for _, e := range es {
+		if match(doc[s.start+e.start : s.start+e.end]) {
+			return splice(doc, span{s.start + e.start, s.start + e.end}, string(v))
+		}
+	}
+	if len(es) == 0 {
+		return splice(doc, s, "["+string(v)+"]")
+	}
+	e := es[len(es)-1]
+	sep := ", "
+	if bytes.Contains(doc[s.start:s.start+e.start], []byte("\n")) {
+		sep = ",\n" + indent(doc, s.start+e.start)
+	}
+	return splice(doc, span{s.start + e.end, s.start + e.end}, sep+string(v))
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const legacyDoc = `# the station
+start    8:00AM      # when it starts
+duration 3h0m0s      # how long it lasts
+
+slot saturday sat 8:00AM 3h  # weekends
+data     /data       # the library
+ignore   /data/a     # first
+ignore   "/data/b c" # second
+`
+
+const jsonDoc = `{
+  "data": "/data",
+  "slots": [
+    {"name": "saturday", "days": "sat", "start": "8:00AM", "duration": "3h"}
+  ],
+  "seed": 1
+}
+`
+
+func TestEdit(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		path string
+		doc  string
+		edit func(c *Config) error
+		// the doc once edited is doc with old replaced by new
+		old, new string
+	}{
+		{
+			"set keeps the comments aligned", "smc.conf", legacyDoc,
+			func(c *Config) error { return c.Set("duration", "2h") },
+			"duration 3h0m0s      # how long it lasts\n", "duration 2h          # how long it lasts\n",
+		},
+		{
+			"set a longer value", "smc.conf", legacyDoc,
+			func(c *Config) error { return c.Set("data", "/media/my shows") },
+			"data     /data       # the library\n", "data     \"/media/my shows\" # the library\n",
+		},
+		{
+			"set a new key", "smc.conf", legacyDoc,
+			func(c *Config) error { return c.Set("timezone", "Europe/Paris") },
+			"ignore   \"/data/b c\" # second\n", "ignore   \"/data/b c\" # second\ntimezone Europe/Paris\n",
+		},
+		{
+			"replace a slot", "smc.conf", legacyDoc,
+			func(c *Config) error { return c.SetSlot("saturday", "sat", "9:00AM", 2*time.Hour) },
+			"slot saturday sat 8:00AM 3h  # weekends\n", "slot saturday sat 9:00AM 2h0m0s # weekends\n",
+		},
+		{
+			"add a slot", "smc.conf", legacyDoc,
+			func(c *Config) error { return c.SetSlot("sunday", "sun", "9:30AM", 2*time.Hour) },
+			"slot saturday sat 8:00AM 3h  # weekends\n", "slot saturday sat 8:00AM 3h  # weekends\nslot sunday sun 9:30AM 2h0m0s\n",
+		},
+		{
+			"add an ignored file", "smc.conf", legacyDoc,
+			func(c *Config) error { return c.AddIgnore("/data/d \"e\"") },
+			"ignore   \"/data/b c\" # second\n", "ignore   \"/data/b c\" # second\nignore   \"/data/d \\\"e\\\"\"\n",
+		},
+		{
+			"set a JSON key", "smc.json", jsonDoc,
+			func(c *Config) error { return c.Set("data", "/media") },
+			"\"data\": \"/data\"", "\"data\": \"/media\"",
+		},
+		{
+			"set a JSON number", "smc.json", jsonDoc,
+			func(c *Config) error { return c.Set("seed", "42") },
+			"\"seed\": 1", "\"seed\": 42",
+		},
+		{
+			"set a new JSON key", "smc.json", jsonDoc,
+			func(c *Config) error { return c.Set("timezone", "UTC") },
+			"\"seed\": 1\n", "\"seed\": 1,\n  \"timezone\": \"UTC\"\n",
+		},
+		{
+			"replace a JSON slot", "smc.json", jsonDoc,
+			func(c *Config) error { return c.SetSlot("saturday", "sat", "9:00AM", 2*time.Hour) },
+			"{\"name\": \"saturday\", \"days\": \"sat\", \"start\": \"8:00AM\", \"duration\": \"3h\"}", "{\"name\":\"saturday\",\"days\":\"sat\",\"start\":\"9:00AM\",\"duration\":\"2h0m0s\"}",
+		},
+		{
+			"add a JSON slot", "smc.json", jsonDoc,
+			func(c *Config) error { return c.SetSlot("sunday", "sun", "9:30AM", 2*time.Hour) },
+			"\"duration\": \"3h\"}\n", "\"duration\": \"3h\"},\n    {\"name\":\"sunday\",\"days\":\"sun\",\"start\":\"9:30AM\",\"duration\":\"2h0m0s\"}\n",
+		},
+		{
+			"add a JSON ignore list", "smc.json", jsonDoc,
+			func(c *Config) error { return c.AddIgnore("/data/a") },
+			"\"seed\": 1\n", "\"seed\": 1,\n  \"ignore\": [\"/data/a\"]\n",
+		},
+	} {
+		c, err := parse(tt.path, []byte(tt.doc))
+		if err != nil {
+			t.Fatalf("%v: %v", tt.name, err)
+		}
+		if err = tt.edit(c); err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		want := strings.Replace(tt.doc, tt.old, tt.new, 1)
+		if want == tt.doc {
+			t.Fatalf("%v: %q is not in the doc", tt.name, tt.old)
+		}
+		if got := string(c.doc); got != want {
+			t.Errorf("%v:\n%v\nwant:\n%v", tt.name, got, want)
+		}
+	}
+	// invalid edits leave the config untouched
+	c, err := parse("smc.conf", []byte(legacyDoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{
+		"duration": "long",
+		"timezone": "Mars/Olympus",
+		"slot":     "saturday",
+	} {
+		if err := c.Set(key, value); err == nil {
+			t.Errorf("set %v %v: no error", key, value)
+		}
+	}
+	if err := c.SetSlot("sunday", "someday", "9:30AM", time.Hour); err == nil {
+		t.Errorf("slot on someday: no error")
+	}
+	if string(c.doc) != legacyDoc || c.Slots()[0].Duration() != 3*time.Hour {
+		t.Errorf("the config is edited by invalid values:\n%s", c.doc)
+	}
+}
@@ -0,0 +1,753 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error is a configuration error located in the config file.
+type Error struct {
+	File string
+	Line int
+	Col  int
+	Err  error
+}
+
+// ErrorList gathers every error found while reading a config file.
+type ErrorList []*Error
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%v: %v", e.File, e.Err)
+	}
+	return fmt.Sprintf("%v:%v:%v: %v", e.File, e.Line, e.Col, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (l ErrorList) Error() string {
+	s := make([]string, len(l))
+	for i, e := range l {
+		s[i] = e.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+type pos struct {
+	line int
+	col  int
+}
+
+// builder turns directives, whatever the format they come from,
+// into a Config.
+type builder struct {
+	c        *Config
+	start    time.Time
+	each     time.Duration
+	duration time.Duration
+	legacy   bool
+	names    map[string]struct{}
+	errs     ErrorList
+}
+
+func newBuilder(path string) *builder {
+	start, _ := time.Parse(time.Kitchen, "8:00AM")
+	return &builder{
+		c: &Config{
+			path: path,
+			loc:  time.Local,
+		},
+		start:    start,
+		each:     24 * time.Hour,
+		duration: 3 * time.Hour,
+		names:    make(map[string]struct{}),
+	}
+}
+
+func (b *builder) errorf(p pos, format string, a ...interface{}) {
+	b.errs = append(b.errs, &Error{
+		File: b.c.path,
+		Line: p.line,
+		Col:  p.col,
+		Err:  fmt.Errorf(format, a...),
+	})
+}
+
+func (b *builder) need(p pos, key string, args []string, n int) bool {
+	if len(args) < n {
+		if n == 1 {
+			b.errorf(p, "'%v' lacks argument", key)
+		} else {
+			b.errorf(p, "'%v' expects %v arguments", key, n)
+		}
+		return false
+	}
+	return true
+}
+
+func (b *builder) path(f string) string {
+	if filepath.IsAbs(f) {
+		return f
+	}
+	return filepath.Join(filepath.Dir(b.c.path), f)
+}
+
+// directive applies the directive `key` at p. The positions of its
+// arguments are in ap, if they are known.
+func (b *builder) directive(p pos, key string, args []string, ap []pos) {
+	var err error
+	at := func(i int) pos {
+		if i < len(ap) {
+			return ap[i]
+		}
+		return p
+	}
+	switch key {
+	case "start":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.start, err = time.Parse(time.Kitchen, args[0]); err != nil {
+			b.errorf(at(0), "invalid start time '%v'", args[0])
+		}
+		b.legacy = true
+	case "each":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.each, err = time.ParseDuration(args[0]); err != nil {
+			b.errorf(at(0), "%v", err)
+		} else if b.each <= 0 {
+			b.errorf(at(0), "'each' must be positive")
+		}
+		b.legacy = true
+	case "duration":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.duration, err = time.ParseDuration(args[0]); err != nil {
+			b.errorf(at(0), "%v", err)
+		} else if b.duration <= 0 {
+			b.errorf(at(0), "'duration' must be positive")
+		}
+		b.legacy = true
+	case "slot":
+		if !b.need(p, key, args, 4) {
+			return
+		}
+		if _, err = parseDays(args[1]); err != nil {
+			b.errorf(at(1), "slot %v: %v", args[0], err)
+			return
+		}
+		if _, err = time.Parse(time.Kitchen, args[2]); err != nil {
+			b.errorf(at(2), "slot %v: invalid start time '%v'", args[0], args[2])
+			return
+		}
+		d, err := time.ParseDuration(args[3])
+		if err != nil {
+			b.errorf(at(3), "slot %v: %v", args[0], err)
+			return
+		}
+		s, err := NewSlot(args[0], args[1], args[2], d)
+		if err != nil {
+			b.errorf(at(3), "%v", err)
+			return
+		}
+		if len(args) > 4 {
+			if err = s.SetOrder(args[4]); err != nil {
+				b.errorf(at(4), "%v", err)
+				return
+			}
+		}
+		if _, exist := b.names[s.Name]; exist {
+			b.errorf(at(0), "slot %v defined twice", s.Name)
+			return
+		}
+		b.names[s.Name] = struct{}{}
+		b.c.slots = append(b.c.slots, s)
+	case "timezone":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.c.loc, err = time.LoadLocation(args[0]); err != nil {
+			b.errorf(at(0), "unknown time zone '%v'", args[0])
+		}
+	case "data":
+		if b.need(p, key, args, 1) {
+			b.c.dataDir = b.path(args[0])
+		}
+	case "static":
+		if b.need(p, key, args, 1) {
+			b.c.staticDir = b.path(args[0])
+		}
+	case "index":
+		if b.need(p, key, args, 1) {
+			b.c.indexFile = b.path(args[0])
+		}
+	case "state":
+		if b.need(p, key, args, 1) {
+			b.c.stateFile = b.path(args[0])
+		}
+	case "order":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if !validOrder(args[0]) {
+			b.errorf(at(0), "unknown order '%v'", args[0])
+			return
+		}
+		b.c.order = args[0]
+	case "filler":
+		if b.need(p, key, args, 1) {
+			b.c.fillerDir = b.path(args[0])
+		}
+	case "ident", "bumper", "signoff":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		cl := Interstitial{Kind: key, Dir: b.path(args[0])}
+		if key == "bumper" {
+			cl.Every = 1
+		}
+		if len(args) > 1 {
+			if err = cl.setRule(args[1:]); err != nil {
+				b.errorf(at(1), "%v: %v", key, err)
+				return
+			}
+		}
+		if b.c.clips == nil {
+			b.c.clips = make(map[string]Interstitial)
+		}
+		b.c.clips[key] = cl
+	case "history":
+		if b.need(p, key, args, 1) {
+			b.c.historyFile = b.path(args[0])
+		}
+	case "no-repeat":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.c.noRepeat, err = parseNoRepeat(args[0]); err != nil {
+			b.errorf(at(0), "%v", err)
+		}
+	case "history-retention":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if args[0] == "forever" {
+			b.c.retention = Forever
+		} else if b.c.retention, err = parseDuration(args[0]); err != nil {
+			b.errorf(at(0), "invalid history-retention '%v'", args[0])
+		}
+	case "seed":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.c.seed, err = strconv.ParseInt(args[0], 10, 64); err != nil {
+			b.errorf(at(0), "'%v' expects a number", key)
+			return
+		}
+		b.c.seeded = true
+	case "probe-workers":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.c.probeWorkers, err = strconv.Atoi(args[0]); err != nil || b.c.probeWorkers < 1 {
+			b.errorf(at(0), "'%v' expects a positive number", key)
+		}
+	case "transcode-jobs":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.c.jobs, err = strconv.Atoi(args[0]); err != nil || b.c.jobs < 1 {
+			b.errorf(at(0), "'%v' expects a positive number", key)
+		}
+	case "transcode-threads":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.c.threads, err = strconv.Atoi(args[0]); err != nil || b.c.threads < 0 {
+			b.errorf(at(0), "'%v' expects a number of threads, 0 for automatic", key)
+		}
+	case "buffer":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.c.buffer, err = time.ParseDuration(args[0]); err != nil {
+			b.errorf(at(0), "%v", err)
+		} else if b.c.buffer < 0 {
+			b.errorf(at(0), "'buffer' must not be negative")
+		}
+		b.c.buffered = true
+	case "cache":
+		if b.need(p, key, args, 1) {
+			b.c.cacheDir = b.path(args[0])
+		}
+	case "cache-size":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		if b.c.cacheSize, err = parseSize(args[0]); err != nil {
+			b.errorf(at(0), "%v", err)
+		}
+	case "skip", "ignore":
+		if !b.need(p, key, args, 1) {
+			return
+		}
+		base := b.c.dataDir
+		if base == "" {
+			// `dataDir` is not defined yet
+			// the path is relative to config location
+			base = filepath.Dir(b.c.path)
+		}
+		for i, a := range args {
+			pt, err := NewPattern(a, base)
+			if err != nil {
+				b.errorf(at(i), "%v", err)
+				continue
+			}
+			b.c.ignore = append(b.c.ignore, pt)
+			log.Println("config: ignore", pt)
+		}
+	default:
+		b.errorf(p, "unknown directive '%v'", key)
+	}
+}
+
+func (b *builder) finish() (*Config, error) {
+	if b.legacy {
+		s := newLegacySlot(b.start, b.each, b.duration)
+		b.c.slots = append([]*Slot{s}, b.c.slots...)
+		b.c.legacy = true
+	}
+	if len(b.c.slots) == 0 && len(b.errs) == 0 {
+		b.errorf(pos{}, "%v", ErrNoSlot)
+	}
+	if len(b.errs) != 0 {
+		return nil, b.errs
+	}
+	return b.c, nil
+}
+
+func isJSON(path string, data []byte) bool {
+	if filepath.Ext(path) == ".json" {
+		return true
+	}
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
+
+func parse(path string, data []byte) (c *Config, err error) {
+	if isJSON(path, data) {
+		c, err = parseJSON(path, data)
+	} else {
+		c, err = parseLegacy(path, data)
+	}
+	if err == nil {
+		c.doc = data
+	}
+	return
+}
+
+func parseLegacy(path string, data []byte) (*Config, error) {
+	b := newBuilder(path)
+	for i, l := range strings.Split(string(data), "\n") {
+		fs, err := SplitFields(l)
+		if err != nil {
+			col := 1
+			var e *Error
+			if errors.As(err, &e) {
+				col, err = e.Col, e.Err
+			}
+			b.errorf(pos{i + 1, col}, "%v", err)
+			continue
+		}
+		if len(fs) < 1 {
+			continue
+		}
+		args := make([]string, len(fs)-1)
+		ap := make([]pos, len(fs)-1)
+		for j, f := range fs[1:] {
+			args[j], ap[j] = f.Value, pos{i + 1, f.Start + 1}
+		}
+		key := fs[0].Value
+		if (key == "ignore" || key == "skip") && len(fs) > 2 && !quoted(l, fs[1:]) {
+			// unquoted file names may contain spaces, as they always
+			// could: several patterns on a line have to be quoted
+			args, ap = []string{l[fs[1].Start:fs[len(fs)-1].End]}, ap[:1]
+		}
+		b.directive(pos{i + 1, fs[0].Start + 1}, key, args, ap)
+	}
+	return b.finish()
+}
+
+// quoted reports whether any of the fields fs of l is quoted.
+func quoted(l string, fs []Field) bool {
+	for _, f := range fs {
+		if strings.ContainsAny(l[f.Start:f.End], `"'`) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonConfig is the schema of JSON config files.
+type jsonConfig struct {
+	Timezone string     `json:"timezone,omitempty"`
+	Start    string     `json:"start,omitempty"`
+	Each     string     `json:"each,omitempty"`
+	Duration string     `json:"duration,omitempty"`
+	Slots    []jsonSlot `json:"slots,omitempty"`
+	Data     string     `json:"data,omitempty"`
+	Static   string     `json:"static,omitempty"`
+	Index    string     `json:"index,omitempty"`
+	State    string     `json:"state,omitempty"`
+	Order    string     `json:"order,omitempty"`
+	Filler   string     `json:"filler,omitempty"`
+	Ident    *jsonClip  `json:"ident,omitempty"`
+	Bumper   *jsonClip  `json:"bumper,omitempty"`
+	Signoff  *jsonClip  `json:"signoff,omitempty"`
+	History  string     `json:"history,omitempty"`
+	NoRepeat string     `json:"no-repeat,omitempty"`
+	Retain   string     `json:"history-retention,omitempty"`
+	Seed     *int64     `json:"seed,omitempty"`
+	Probe    int        `json:"probe-workers,omitempty"`
+	Jobs     int        `json:"transcode-jobs,omitempty"`
+	Threads  int        `json:"transcode-threads,omitempty"`
+	Buffer   string     `json:"buffer,omitempty"`
+	Cache    string     `json:"cache,omitempty"`
+	CacheMax string     `json:"cache-size,omitempty"`
+	Ignore   []string   `json:"ignore,omitempty"`
+}
+
+// parseSize parses a number of bytes, possibly followed by K, M, G or T.
+func parseSize(s string) (int64, error) {
+	n, unit := s, int64(1)
+	if l := len(s); l > 0 {
+		if i := strings.IndexByte("KMGT", s[l-1]); i >= 0 {
+			n, unit = s[:l-1], 1<<(10*(i+1))
+		}
+	}
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid size '%v'", s)
+	}
+	return v * unit, nil
+}
+
+// parseNoRepeat parses `exhaust`, `off` or a duration, possibly in days.
+func parseNoRepeat(s string) (time.Duration, error) {
+	switch s {
+	case "exhaust":
+		return Exhaust, nil
+	case "off":
+		return Repeat, nil
+	}
+	d, err := parseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid no-repeat '%v'", s)
+	}
+	return d, nil
+}
+
+// parseDuration parses a positive duration, possibly in days.
+func parseDuration(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		if n, err := strconv.Atoi(strings.TrimSuffix(s, "d")); err == nil && n > 0 {
+			return time.Duration(n) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid duration '%v'", s)
+}
+
+type jsonClip struct {
+	Dir   string `json:"dir"`
+	Every string `json:"every,omitempty"`
+}
+
+type jsonSlot struct {
+	Name     string `json:"name"`
+	Days     string `json:"days"`
+	Start    string `json:"start"`
+	Duration string `json:"duration"`
+	Order    string `json:"order,omitempty"`
+}
+
+type jsonParser struct {
+	b    *builder
+	data []byte
+	dec  *json.Decoder
+}
+
+var errSyntax = errors.New("syntax error")
+
+func parseJSON(path string, data []byte) (*Config, error) {
+	p := &jsonParser{
+		b:    newBuilder(path),
+		data: data,
+		dec:  json.NewDecoder(bytes.NewReader(data)),
+	}
+	var (
+		jc    jsonConfig
+		poss  = make(map[string]pos)
+		slots []pos
+	)
+	err := p.object(func(key string, kp pos) error {
+		var v interface{}
+		switch key {
+		case "timezone":
+			v = &jc.Timezone
+		case "start":
+			v = &jc.Start
+		case "each":
+			v = &jc.Each
+		case "duration":
+			v = &jc.Duration
+		case "data":
+			v = &jc.Data
+		case "static":
+			v = &jc.Static
+		case "index":
+			v = &jc.Index
+		case "state":
+			v = &jc.State
+		case "order":
+			v = &jc.Order
+		case "filler":
+			v = &jc.Filler
+		case "ident":
+			v = &jc.Ident
+		case "bumper":
+			v = &jc.Bumper
+		case "signoff":
+			v = &jc.Signoff
+		case "history":
+			v = &jc.History
+		case "no-repeat":
+			v = &jc.NoRepeat
+		case "history-retention":
+			v = &jc.Retain
+		case "seed":
+			v = &jc.Seed
+		case "probe-workers":
+			v = &jc.Probe
+		case "transcode-jobs":
+			v = &jc.Jobs
+		case "transcode-threads":
+			v = &jc.Threads
+		case "buffer":
+			v = &jc.Buffer
+		case "cache":
+			v = &jc.Cache
+		case "cache-size":
+			v = &jc.CacheMax
+		case "ignore":
+			v = &jc.Ignore
+		case "slots":
+			return p.array(func() error {
+				var s jsonSlot
+				slots = append(slots, p.pos())
+				err := p.object(func(key string, kp pos) error {
+					switch key {
+					case "name":
+						return p.value(&s.Name)
+					case "days":
+						return p.value(&s.Days)
+					case "start":
+						return p.value(&s.Start)
+					case "duration":
+						return p.value(&s.Duration)
+					case "order":
+						return p.value(&s.Order)
+					}
+					p.b.errorf(kp, "unknown slot key '%v'", key)
+					return p.value(nil)
+				})
+				if err != nil {
+					return err
+				}
+				for i, v := range []string{s.Name, s.Days, s.Start, s.Duration} {
+					if v == "" {
+						k := []string{"name", "days", "start", "duration"}[i]
+						p.b.errorf(slots[len(slots)-1], "slot lacks '%v'", k)
+					}
+				}
+				jc.Slots = append(jc.Slots, s)
+				return nil
+			})
+		default:
+			p.b.errorf(kp, "unknown key '%v'", key)
+			return p.value(nil)
+		}
+		poss[key] = p.pos()
+		return p.value(v)
+	})
+	if err != nil {
+		return nil, p.b.errs
+	}
+
+	// the order matters: `ignore` is relative to `data`
+	str := func(key, v string) {
+		if v != "" {
+			p.b.directive(poss[key], key, []string{v}, nil)
+		}
+	}
+	str("timezone", jc.Timezone)
+	str("start", jc.Start)
+	str("each", jc.Each)
+	str("duration", jc.Duration)
+	for i, s := range jc.Slots {
+		if s.Name != "" && s.Days != "" && s.Start != "" && s.Duration != "" {
+			args := []string{s.Name, s.Days, s.Start, s.Duration}
+			if s.Order != "" {
+				args = append(args, s.Order)
+			}
+			p.b.directive(slots[i], "slot", args, nil)
+		}
+	}
+	str("data", jc.Data)
+	str("static", jc.Static)
+	str("index", jc.Index)
+	str("state", jc.State)
+	str("order", jc.Order)
+	str("filler", jc.Filler)
+	for i, cl := range []*jsonClip{jc.Ident, jc.Bumper, jc.Signoff} {
+		key := Interstitials[i]
+		if cl == nil {
+			continue
+		}
+		args := []string{cl.Dir}
+		if cl.Every != "" {
+			args = append(args, "every", cl.Every)
+		}
+		p.b.directive(poss[key], key, args, nil)
+	}
+	str("history", jc.History)
+	str("no-repeat", jc.NoRepeat)
+	str("history-retention", jc.Retain)
+	if jc.Seed != nil {
+		p.b.directive(poss["seed"], "seed", []string{strconv.FormatInt(*jc.Seed, 10)}, nil)
+	}
+	if jc.Probe != 0 {
+		p.b.directive(poss["probe-workers"], "probe-workers", []string{strconv.Itoa(jc.Probe)}, nil)
+	}
+	if jc.Jobs != 0 {
+		p.b.directive(poss["transcode-jobs"], "transcode-jobs", []string{strconv.Itoa(jc.Jobs)}, nil)
+	}
+	if jc.Threads != 0 {
+		p.b.directive(poss["transcode-threads"], "transcode-threads", []string{strconv.Itoa(jc.Threads)}, nil)
+	}
+	str("buffer", jc.Buffer)
+	str("cache", jc.Cache)
+	str("cache-size", jc.CacheMax)
+	for _, f := range jc.Ignore {
+		p.b.directive(poss["ignore"], "ignore", []string{f}, nil)
+	}
+	return p.b.finish()
+}
+
+// pos returns the position of the next token.
+func (p *jsonParser) pos() pos {
+	return p.at(int(p.dec.InputOffset()), true)
+}
+
+func (p *jsonParser) at(off int, skip bool) pos {
+	if off > len(p.data) {
+		off = len(p.data)
+	}
+	for skip && off < len(p.data) && strings.IndexByte(" \t\r\n,:", p.data[off]) >= 0 {
+		off++
+	}
+	before := p.data[:off]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := off - bytes.LastIndexByte(before, '\n')
+	return pos{line, col}
+}
+
+func (p *jsonParser) token() (json.Token, error) {
+	t, err := p.dec.Token()
+	if err != nil {
+		var serr *json.SyntaxError
+		if errors.As(err, &serr) {
+			p.b.errorf(p.at(int(serr.Offset)-1, false), "%v", err)
+		} else {
+			p.b.errorf(p.at(len(p.data), false), "unexpected end of file")
+		}
+		return nil, errSyntax
+	}
+	return t, nil
+}
+
+func (p *jsonParser) delim(d json.Delim) error {
+	tp := p.pos()
+	t, err := p.token()
+	if err != nil {
+		return err
+	}
+	if t != d {
+		p.b.errorf(tp, "expected '%v'", d)
+		return errSyntax
+	}
+	return nil
+}
+
+func (p *jsonParser) object(field func(key string, kp pos) error) error {
+	if err := p.delim('{'); err != nil {
+		return err
+	}
+	for p.dec.More() {
+		kp := p.pos()
+		t, err := p.token()
+		if err != nil {
+			return err
+		}
+		if err = field(t.(string), kp); err != nil {
+			return err
+		}
+	}
+	return p.delim('}')
+}
+
+func (p *jsonParser) array(elem func() error) error {
+	if err := p.delim('['); err != nil {
+		return err
+	}
+	for p.dec.More() {
+		if err := elem(); err != nil {
+			return err
+		}
+	}
+	return p.delim(']')
+}
+
+// value decodes the next value into v, or skips it if v is nil.
+func (p *jsonParser) value(v interface{}) error {
+	vp := p.pos()
+	var raw json.RawMessage
+	if err := p.dec.Decode(&raw); err != nil {
+		p.b.errorf(vp, "%v", err)
+		return errSyntax
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		var terr *json.UnmarshalTypeError
+		if errors.As(err, &terr) {
+			p.b.errorf(vp, "expected %v, got %v", terr.Type, terr.Value)
+		} else {
+			p.b.errorf(vp, "%v", err)
+		}
+	}
+	return nil
+}
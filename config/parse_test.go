@@ -0,0 +1,114 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// errorsOf returns the errors of parsing conf as a file named path.
+func errorsOf(t *testing.T, path, conf string) ErrorList {
+	t.Helper()
+	_, err := parse(path, []byte(conf))
+	if err == nil {
+		return nil
+	}
+	var errs ErrorList
+	if !errors.As(err, &errs) {
+		t.Fatalf("%v is not an ErrorList", err)
+	}
+	return errs
+}
+
+func TestJSONErrors(t *testing.T) {
+	for _, tt := range []struct {
+		conf      string
+		line, col int
+		err       string
+	}{
+		{
+			"{\n  \"data\": \"/data\",\n  \"unknown\": 1\n}\n",
+			3, 3, "unknown key 'unknown'",
+		},
+		{
+			"{\n  \"probe-workers\": \"four\"\n}\n",
+			2, 20, "expected int, got string",
+		},
+		{
+			"{\n  \"slots\": [\n    {\"name\": \"a\", \"days\": \"sat\", \"start\": \"8:00AM\"}\n  ]\n}\n",
+			3, 5, "slot lacks 'duration'",
+		},
+		{
+			"{\n  \"slots\": [\n    {\"name\": \"a\", \"colour\": \"red\"}\n  ]\n}\n",
+			3, 19, "unknown slot key 'colour'",
+		},
+		{
+			"{\n  \"slots\": [\n    {\"name\": \"a\", \"days\": \"someday\", \"start\": \"8:00AM\", \"duration\": \"1h\"}\n  ]\n}\n",
+			3, 5, "unknown day 'someday'",
+		},
+		{
+			"{\n  \"data\": \"/data\"\n  \"static\": \"/static\"\n}\n",
+			3, 3, "invalid character",
+		},
+		{
+			"{\n  \"data\": \"/data\",\n",
+			2, 19, "unexpected end of JSON input",
+		},
+		{
+			"[\"data\"]\n",
+			1, 1, "expected '{'",
+		},
+		{
+			"{\n  \"timezone\": \"Mars/Olympus\",\n  \"slots\": []\n}\n",
+			2, 15, "unknown time zone 'Mars/Olympus'",
+		},
+	} {
+		errs := errorsOf(t, "smc.json", tt.conf)
+		if len(errs) == 0 {
+			t.Errorf("%q: no error, want %v", tt.conf, tt.err)
+			continue
+		}
+		e := errs[0]
+		if e.Line != tt.line || e.Col != tt.col || !strings.Contains(e.Err.Error(), tt.err) {
+			t.Errorf("%q: error %v, want smc.json:%v:%v: %v", tt.conf, e, tt.line, tt.col, tt.err)
+		}
+	}
+
+	// every error is reported, not only the first one
+	conf := "{\n  \"a\": 1,\n  \"b\": 2,\n  \"order\": \"random\"\n}\n"
+	if errs := errorsOf(t, "smc.json", conf); len(errs) != 3 {
+		t.Errorf("%v errors %v, want 3", len(errs), errs)
+	}
+}
+
+func TestLegacyErrors(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		col  int
+		err  string
+	}{
+		{"unknown 1", 1, "unknown directive 'unknown'"},
+		{"  data", 3, "'data' lacks argument"},
+		{"start   25:00PM", 9, "invalid start time '25:00PM'"},
+		{"slot a  someday 8:00AM 1h", 9, "unknown day 'someday'"},
+		{"slot a sat 8:00XM 1h", 12, "invalid start time '8:00XM'"},
+		{"slot a sat 8:00AM 1x", 19, "unknown unit"},
+		{"slot a sat 8:00AM 1h random", 22, "unknown order 'random'"},
+		{"bumper /b every  zero", 11, "'every' expects a positive number"},
+		{"timezone Mars/Olympus", 10, "unknown time zone 'Mars/Olympus'"},
+		{"cache-size 12Q", 12, "invalid size '12Q'"},
+		{"history-retention 0d", 19, "invalid history-retention '0d'"},
+		{`ignore "a" "re:("`, 12, "missing closing )"},
+		{`data "/data`, 6, "unterminated quote"},
+	} {
+		errs := errorsOf(t, "smc.conf", "start 8:00AM\n"+tt.line+"\n")
+		if len(errs) != 1 {
+			t.Errorf("%q: errors %v, want one", tt.line, errs)
+			continue
+		}
+		e := errs[0]
+		if e.Line != 2 || e.Col != tt.col || !strings.Contains(e.Err.Error(), tt.err) {
+			t.Errorf("%q: error %v, want smc.conf:2:%v: %v", tt.line, e, tt.col, tt.err)
+		}
+	}
+}
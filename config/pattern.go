@@ -0,0 +1,190 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Pattern matches file paths. It is either an exact path, a glob
+// (`*`, `?`, `[...]` and `**` matching any number of directories)
+// or, when prefixed with `re:`, a regular expression.
+type Pattern struct {
+	s    string
+	path string
+	re   *regexp.Regexp
+	name bool
+}
+
+// Field is a word of a config line, quotes removed.
+type Field struct {
+	Value string
+	Start int
+	End   int
+}
+
+// NewPattern parses s. Relative patterns are anchored at base; if base
+// is empty the pattern matches file names instead of paths.
+func NewPattern(s, base string) (*Pattern, error) {
+	p := &Pattern{s: s}
+	if strings.HasPrefix(s, "re:") {
+		re, err := regexp.Compile(s[3:])
+		if err != nil {
+			return nil, err
+		}
+		p.re, p.name = re, base == ""
+		return p, nil
+	}
+	if !filepath.IsAbs(s) {
+		if base == "" {
+			p.name = true
+		} else {
+			s = filepath.Join(base, s)
+		}
+	}
+	if !strings.ContainsAny(s, "*?") {
+		// names such as `Show [1080p]` are still matched as they are
+		p.path = s
+	}
+	if !strings.ContainsAny(s, "*?[") {
+		return p, nil
+	}
+	re, err := regexp.Compile(globToRegexp(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern '%v'", p.s)
+	}
+	p.re = re
+	return p, nil
+}
+
+func (p *Pattern) Match(path string) bool {
+	if p.name {
+		path = filepath.Base(path)
+	}
+	if p.re != nil && p.re.MatchString(path) {
+		return true
+	}
+	return p.path != "" && p.path == path
+}
+
+func (p *Pattern) String() string {
+	return p.s
+}
+
+// MatchAny reports whether any of ps matches path.
+func MatchAny(ps []*Pattern, path string) bool {
+	for _, p := range ps {
+		if p.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func globToRegexp(g string) string {
+	re := "^"
+	for i := 0; i < len(g); i++ {
+		switch c := g[i]; c {
+		case '*':
+			if i+1 < len(g) && g[i+1] == '*' {
+				i++
+				if i+1 < len(g) && g[i+1] == '/' {
+					i++
+					re += "(?:.*/)?"
+				} else {
+					re += ".*"
+				}
+			} else {
+				re += "[^/]*"
+			}
+		case '?':
+			re += "[^/]"
+		case '[':
+			j := strings.IndexByte(g[i+1:], ']')
+			if j < 0 {
+				re += `\[`
+				continue
+			}
+			class := g[i+1 : i+1+j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			re += "[" + strings.ReplaceAll(class, `\`, `\\`) + "]"
+			i += j + 1
+		case '\\':
+			if i+1 < len(g) {
+				i++
+				re += regexp.QuoteMeta(g[i : i+1])
+			} else {
+				re += `\\`
+			}
+		default:
+			re += regexp.QuoteMeta(string(c))
+		}
+	}
+	return re + "$"
+}
+
+var errUnterminated = errors.New("unterminated quote")
+
+// SplitFields splits a config line into fields. Fields are separated by
+// spaces unless quoted: inside double quotes `\"` and `\\` stand for
+// `"` and `\`, single quotes are taken literally. An unquoted `#`
+// at the beginning of a field starts a comment. Errors are *Error
+// giving the column of the faulty quote.
+func SplitFields(l string) ([]Field, error) {
+	var fs []Field
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\r'
+	}
+	for i := 0; ; {
+		for i < len(l) && isSpace(l[i]) {
+			i++
+		}
+		if i == len(l) || l[i] == '#' {
+			return fs, nil
+		}
+		f := Field{Start: i}
+		var b strings.Builder
+		for i < len(l) && !isSpace(l[i]) {
+			switch c := l[i]; c {
+			case '"':
+				q := i
+				for i++; i < len(l) && l[i] != '"'; i++ {
+					if l[i] == '\\' && i+1 < len(l) && (l[i+1] == '"' || l[i+1] == '\\') {
+						i++
+					}
+					b.WriteByte(l[i])
+				}
+				if i == len(l) {
+					return fs, &Error{Col: q + 1, Err: errUnterminated}
+				}
+				i++
+			case '\'':
+				q := i
+				j := strings.IndexByte(l[i+1:], '\'')
+				if j < 0 {
+					return fs, &Error{Col: q + 1, Err: errUnterminated}
+				}
+				b.WriteString(l[i+1 : i+1+j])
+				i += j + 2
+			default:
+				b.WriteByte(c)
+				i++
+			}
+		}
+		f.Value, f.End = b.String(), i
+		fs = append(fs, f)
+	}
+}
+
+// Quote quotes s if needed so that SplitFields reads it as a single field.
+func Quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\r#\"'") {
+		return s
+	}
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
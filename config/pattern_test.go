@@ -0,0 +1,116 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPattern(t *testing.T) {
+	for _, tt := range []struct {
+		pattern, base string
+		match         []string
+		noMatch       []string
+	}{
+		{"/data/a b", "", []string{"/data/a b"}, []string{"/data/a", "/data/a b/c"}},
+		{"extras", "/data", []string{"/data/extras"}, []string{"/data/show/extras"}},
+		{"extras", "", []string{"/data/extras", "/data/show/extras"}, []string{"/data/extras2"}},
+		{"*.sample.mkv", "", []string{"/data/a.sample.mkv"}, []string{"/data/a.mkv"}},
+		{"*.mkv", "/data", []string{"/data/a.mkv"}, []string{"/data/show/a.mkv"}},
+		{"**/extras", "/data", []string{"/data/extras", "/data/a/b/extras"}, []string{"/data/a/extras2", "/extras"}},
+		{"**/*.mkv", "/data", []string{"/data/a.mkv", "/data/a/b.mkv"}, []string{"/data/a.mp4"}},
+		{"show/**", "/data", []string{"/data/show/a", "/data/show/a/b"}, []string{"/data/shows/a"}},
+		{"ep?.mkv", "", []string{"/data/ep1.mkv"}, []string{"/data/ep10.mkv", "/data/ep/.mkv"}},
+		{"ep[0-4].mkv", "", []string{"/data/ep3.mkv"}, []string{"/data/ep5.mkv"}},
+		{"ep[!0-4].mkv", "", []string{"/data/ep5.mkv"}, []string{"/data/ep3.mkv"}},
+		{"[abc", "", []string{"/data/[abc"}, []string{"/data/a"}},
+		{"Show [1080p]", "", []string{"/data/Show [1080p]", "/data/Show 1"}, []string{"/data/Show 2"}},
+		{`a\*.mkv`, "", []string{"/data/a*.mkv"}, []string{"/data/ab.mkv"}},
+		{"a.b*", "", []string{"/data/a.bc"}, []string{"/data/axbc"}},
+		{"re:/trailers?/", "/data", []string{"/data/trailer/a.mkv", "/data/x/trailers/b.mkv"}, []string{"/data/trail/a.mkv"}},
+		{"re:^sample", "", []string{"/data/sample.mkv"}, []string{"/data/a sample.mkv"}},
+	} {
+		p, err := NewPattern(tt.pattern, tt.base)
+		if err != nil {
+			t.Errorf("%v: %v", tt.pattern, err)
+			continue
+		}
+		for _, f := range tt.match {
+			if !p.Match(f) {
+				t.Errorf("%v (in %q) does not match %v", tt.pattern, tt.base, f)
+			}
+		}
+		for _, f := range tt.noMatch {
+			if p.Match(f) {
+				t.Errorf("%v (in %q) matches %v", tt.pattern, tt.base, f)
+			}
+		}
+	}
+	if _, err := NewPattern("re:(", ""); err == nil {
+		t.Errorf("re:( is a valid pattern")
+	}
+}
+
+func TestSplitFields(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want []Field
+	}{
+		{"", nil},
+		{"  # a comment", nil},
+		{"ignore a  b", []Field{{"ignore", 0, 6}, {"a", 7, 8}, {"b", 10, 11}}},
+		{"ignore \"a b\" # c", []Field{{"ignore", 0, 6}, {"a b", 7, 12}}},
+		{`ignore "a \"b\" \\ \c"`, []Field{{"ignore", 0, 6}, {`a "b" \ \c`, 7, 22}}},
+		{`ignore 'a "b" \'`, []Field{{"ignore", 0, 6}, {`a "b" \`, 7, 16}}},
+		{`ignore a"b c"d`, []Field{{"ignore", 0, 6}, {"ab cd", 7, 14}}},
+		{"ignore a#b", []Field{{"ignore", 0, 6}, {"a#b", 7, 10}}},
+		{"ignore \"\"", []Field{{"ignore", 0, 6}, {"", 7, 9}}},
+		{"\tdata\t/data\r", []Field{{"data", 1, 5}, {"/data", 6, 11}}},
+	} {
+		fs, err := SplitFields(tt.line)
+		if err != nil {
+			t.Errorf("%q: %v", tt.line, err)
+		} else if !reflect.DeepEqual(fs, tt.want) {
+			t.Errorf("%q: fields %v, want %v", tt.line, fs, tt.want)
+		}
+	}
+	for _, l := range []string{`ignore "a b`, `ignore 'a b`, `ignore "a \"`} {
+		if _, err := SplitFields(l); err == nil {
+			t.Errorf("%q: no error", l)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, s := range []string{"a", "", "a b", "a#b", "#a", `a "b"`, `a\b`, `a'b`, "a\tb", `\"`} {
+		q := Quote(s)
+		fs, err := SplitFields("ignore " + q + " # comment")
+		if err != nil || len(fs) != 2 || fs[1].Value != s {
+			t.Errorf("%q quoted as %v, read back as %v (%v)", s, q, fs, err)
+		}
+	}
+	if q := Quote("/data/a"); q != "/data/a" {
+		t.Errorf("/data/a quoted as %v", q)
+	}
+}
+
+func TestIgnoreLine(t *testing.T) {
+	for _, tt := range []struct {
+		line string
+		want []string
+	}{
+		{"ignore /data/a", []string{"/data/a"}},
+		{"ignore /data/My  Show # a comment", []string{"/data/My  Show"}},
+		{`ignore "/data/My Show"`, []string{"/data/My Show"}},
+		{`ignore "*.nfo" "My Show"`, []string{"*.nfo", "My Show"}},
+		{`ignore *.nfo 'My Show'`, []string{"*.nfo", "My Show"}},
+	} {
+		c := mustParse(t, "start 8:00AM\ndata /data\n"+tt.line+"\n")
+		var got []string
+		for _, p := range c.ignore {
+			got = append(got, p.String())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: patterns %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Slot is a recurring broadcast: it starts at the same time of day on
+// each of its days and lasts for the given duration. Slots created from
+// the legacy `start/each/duration` triple repeat every `each` instead.
+type Slot struct {
+	Name     string
+	days     [7]bool
+	hour     int
+	min      int
+	sec      int
+	duration time.Duration
+	each     time.Duration
+	order    string
+}
+
+// Window is a single occurrence of a slot. Order is the scheduling
+// strategy of the slot, empty for the one of the station.
+type Window struct {
+	Slot  string
+	Order string
+	Start time.Time
+	End   time.Time
+}
+
+var (
+	ErrNoSlot error = errors.New("no broadcast slot defined")
+
+	dayNames = map[string]time.Weekday{
+		"sun": time.Sunday, "sunday": time.Sunday,
+		"mon": time.Monday, "monday": time.Monday,
+		"tue": time.Tuesday, "tuesday": time.Tuesday,
+		"wed": time.Wednesday, "wednesday": time.Wednesday,
+		"thu": time.Thursday, "thursday": time.Thursday,
+		"fri": time.Friday, "friday": time.Friday,
+		"sat": time.Saturday, "saturday": time.Saturday,
+	}
+	// Orders are the names of the scheduling strategies.
+	Orders = []string{"shuffle", "series", "sequential", "weighted", "least-recent"}
+
+	dayOrder = []time.Weekday{
+		time.Monday, time.Tuesday, time.Wednesday, time.Thursday,
+		time.Friday, time.Saturday, time.Sunday,
+	}
+)
+
+func NewSlot(name, days, start string, duration time.Duration) (*Slot, error) {
+	s := &Slot{
+		Name:     name,
+		duration: duration,
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("slot %v: invalid duration %v", name, duration)
+	}
+	if err := s.setStart(start); err != nil {
+		return nil, fmt.Errorf("slot %v: %v", name, err)
+	}
+	ds, err := parseDays(days)
+	if err != nil {
+		return nil, fmt.Errorf("slot %v: %v", name, err)
+	}
+	s.days = ds
+	return s, nil
+}
+
+func validOrder(order string) bool {
+	for _, o := range Orders {
+		if o == order {
+			return true
+		}
+	}
+	return false
+}
+
+func newLegacySlot(start time.Time, each, duration time.Duration) *Slot {
+	s := &Slot{
+		Name:     "default",
+		hour:     start.Hour(),
+		min:      start.Minute(),
+		sec:      start.Second(),
+		duration: duration,
+	}
+	if each == 24*time.Hour {
+		for i := range s.days {
+			s.days[i] = true
+		}
+	} else {
+		s.each = each
+	}
+	return s
+}
+
+func (s *Slot) setStart(start string) error {
+	t, err := time.Parse(time.Kitchen, start)
+	if err != nil {
+		return err
+	}
+	s.hour, s.min, s.sec = t.Hour(), t.Minute(), t.Second()
+	return nil
+}
+
+func (s *Slot) Duration() time.Duration {
+	return s.duration
+}
+
+// Each returns the period of legacy slots, 24h for weekly ones.
+func (s *Slot) Each() time.Duration {
+	if s.each == 0 {
+		return 24 * time.Hour
+	}
+	return s.each
+}
+
+func (s *Slot) Start() string {
+	return time.Date(0, 1, 1, s.hour, s.min, s.sec, 0, time.UTC).Format(time.Kitchen)
+}
+
+func (s *Slot) Days() string {
+	if s.each != 0 {
+		return ""
+	}
+	all := true
+	for _, d := range s.days {
+		all = all && d
+	}
+	if all {
+		return "daily"
+	}
+	var rs []string
+	for i := 0; i < len(dayOrder); i++ {
+		if !s.days[dayOrder[i]] {
+			continue
+		}
+		j := i
+		for j+1 < len(dayOrder) && s.days[dayOrder[j+1]] {
+			j++
+		}
+		r := shortDay(dayOrder[i])
+		if j > i {
+			r += "-" + shortDay(dayOrder[j])
+		}
+		rs = append(rs, r)
+		i = j
+	}
+	return strings.Join(rs, ",")
+}
+
+// Order returns the scheduling strategy of s, if any.
+func (s *Slot) Order() string {
+	return s.order
+}
+
+// SetOrder sets the scheduling strategy of s.
+func (s *Slot) SetOrder(order string) error {
+	if order != "" && !validOrder(order) {
+		return fmt.Errorf("slot %v: unknown order '%v'", s.Name, order)
+	}
+	s.order = order
+	return nil
+}
+
+func (s *Slot) String() string {
+	str := fmt.Sprintf("%v %v %v %v", s.Name, s.Days(), s.Start(), s.duration)
+	if s.order != "" {
+		str += " " + s.order
+	}
+	return str
+}
+
+// next returns the earliest occurrence of s that has not ended yet.
+func (s *Slot) next(now time.Time) Window {
+	at := func(days int) time.Time {
+		return time.Date(now.Year(), now.Month(), now.Day()+days,
+			s.hour, s.min, s.sec, 0, now.Location())
+	}
+
+	if s.each > 0 && s.each%(24*time.Hour) == 0 {
+		// the periods are counted in days from January 1, 1970, not
+		// from today, or they would all start again every day
+		n := int(s.each / (24 * time.Hour))
+		today := int(time.Date(now.Year(), now.Month(), now.Day(),
+			0, 0, 0, 0, time.UTC).Unix() / (24 * 60 * 60))
+		for d := today - today%n - n; ; d += n {
+			start := at(d - today)
+			if end := start.Add(s.duration); end.After(now) {
+				return Window{s.Name, s.order, start, end}
+			}
+		}
+	}
+	if s.each > 0 {
+		base := time.Date(1970, 1, 1, s.hour, s.min, s.sec, 0, now.Location())
+		k := now.Sub(base) / s.each
+		if now.Before(base) {
+			k--
+		}
+		start := base.Add(k * s.each)
+		if !start.Add(s.duration).After(now) {
+			start = start.Add(s.each)
+		}
+		return Window{s.Name, s.order, start, start.Add(s.duration)}
+	}
+
+	for d := -1 - int(s.duration/(24*time.Hour)); d <= 7; d++ {
+		start := at(d)
+		if !s.days[start.Weekday()] {
+			continue
+		}
+		if end := start.Add(s.duration); end.After(now) {
+			return Window{s.Name, s.order, start, end}
+		}
+	}
+	return Window{}
+}
+
+func (w Window) Duration() time.Duration {
+	return w.End.Sub(w.Start)
+}
+
+func (w Window) IsZero() bool {
+	return w.Start.IsZero()
+}
+
+// Contains reports whether t is within w.
+func (w Window) Contains(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+func parseDays(days string) (ds [7]bool, err error) {
+	switch days = strings.ToLower(days); days {
+	case "daily", "*":
+		for i := range ds {
+			ds[i] = true
+		}
+		return
+	case "weekdays":
+		days = "mon-fri"
+	case "weekends":
+		days = "sat-sun"
+	}
+	for _, r := range strings.Split(days, ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		from, ok := dayNames[bounds[0]]
+		if !ok {
+			return ds, fmt.Errorf("unknown day '%v'", bounds[0])
+		}
+		to := from
+		if len(bounds) == 2 {
+			if to, ok = dayNames[bounds[1]]; !ok {
+				return ds, fmt.Errorf("unknown day '%v'", bounds[1])
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			ds[d] = true
+			if d == to {
+				break
+			}
+		}
+	}
+	return
+}
+
+func shortDay(d time.Weekday) string {
+	return strings.ToLower(d.String()[:3])
+}
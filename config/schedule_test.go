@@ -0,0 +1,233 @@
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// mustParse parses the legacy config conf.
+func mustParse(t *testing.T, conf string) *Config {
+	t.Helper()
+	c, err := parse("smc.conf", []byte(conf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestEach(t *testing.T) {
+	for _, tt := range []struct {
+		each string
+		days int
+	}{
+		{"24h", 1},
+		{"48h", 2},
+		{"168h", 7},
+	} {
+		c := mustParse(t, "timezone UTC\nstart 8:00AM\neach "+tt.each+"\nduration 1h\n")
+		var starts []time.Time
+		for d := 0; d < 15; d++ {
+			now := time.Date(2026, 3, 1+d, 12, 0, 0, 0, time.UTC)
+			w := c.NextWindow(now)
+			if w.Start.Hour() != 8 || !w.Start.After(now) {
+				t.Fatalf("each %v: next window of %v starts at %v", tt.each, now, w.Start)
+			}
+			if len(starts) == 0 || !starts[len(starts)-1].Equal(w.Start) {
+				starts = append(starts, w.Start)
+			}
+		}
+		for i := 1; i < len(starts); i++ {
+			if d := starts[i].Sub(starts[i-1]); d != time.Duration(tt.days)*24*time.Hour {
+				t.Errorf("each %v: %v after %v, want %v days", tt.each, starts[i], starts[i-1], tt.days)
+			}
+		}
+		if n := 15 / tt.days; len(starts) < n {
+			t.Errorf("each %v: %v windows in 15 days, want %v", tt.each, len(starts), n)
+		}
+	}
+
+	// weekly slots air on the weekday of January 1, 1970
+	c := mustParse(t, "timezone UTC\nstart 8:00AM\neach 168h\nduration 1h\n")
+	if w := c.NextWindow(time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)); w.Start.Weekday() != time.Thursday {
+		t.Errorf("each 168h airs on %v, want Thursday", w.Start.Weekday())
+	}
+	// periods shorter than a day
+	c = mustParse(t, "timezone UTC\nstart 8:00AM\neach 6h\nduration 1h\n")
+	now := time.Date(2026, 3, 1, 15, 0, 0, 0, time.UTC)
+	if w := c.NextWindow(now); !w.Start.Equal(time.Date(2026, 3, 1, 20, 0, 0, 0, time.UTC)) {
+		t.Errorf("each 6h: next window of %v starts at %v, want 20:00", now, w.Start)
+	}
+	// the running window
+	now = time.Date(2026, 3, 1, 8, 30, 0, 0, time.UTC)
+	if w := c.NextWindow(now); !w.Contains(now) {
+		t.Errorf("each 6h: window %v does not contain %v", w, now)
+	}
+}
+
+func TestParseDays(t *testing.T) {
+	for _, tt := range []struct {
+		days string
+		want string
+	}{
+		{"daily", "daily"},
+		{"*", "daily"},
+		{"weekdays", "mon-fri"},
+		{"weekends", "sat-sun"},
+		{"sat", "sat"},
+		{"Saturday", "sat"},
+		{"mon,wed,fri", "mon,wed,fri"},
+		{"mon-wed,fri", "mon-wed,fri"},
+		{"fri-mon", "mon,fri-sun"},
+		{"sun-sat", "daily"},
+	} {
+		s, err := NewSlot("s", tt.days, "8:00AM", time.Hour)
+		if err != nil {
+			t.Errorf("%v: %v", tt.days, err)
+			continue
+		}
+		if got := s.Days(); got != tt.want {
+			t.Errorf("%v: days %v, want %v", tt.days, got, tt.want)
+		}
+	}
+	for _, days := range []string{"", "moon", "mon-", "mon-xyz", "mon,,tue"} {
+		if _, err := parseDays(days); err == nil {
+			t.Errorf("%q: no error", days)
+		}
+	}
+}
+
+func TestNextWindow(t *testing.T) {
+	c := mustParse(t, `timezone UTC
+slot saturday sat     8:00AM 3h
+slot sunday   sun     9:30AM 2h
+slot weekday  mon-fri 4:00PM 1h
+slot night    fri    11:00PM 2h
+`)
+	at := func(day, hour, min int) time.Time {
+		// March 2026 starts on a Sunday
+		return time.Date(2026, 3, day, hour, min, 0, 0, time.UTC)
+	}
+	for _, tt := range []struct {
+		now        time.Time
+		slot       string
+		start, end time.Time
+	}{
+		{at(1, 0, 0), "sunday", at(1, 9, 30), at(1, 11, 30)},
+		{at(1, 10, 0), "sunday", at(1, 9, 30), at(1, 11, 30)},
+		{at(1, 11, 30), "weekday", at(2, 16, 0), at(2, 17, 0)},
+		{at(2, 17, 0), "weekday", at(3, 16, 0), at(3, 17, 0)},
+		{at(6, 17, 0), "night", at(6, 23, 0), at(7, 1, 0)},
+		// across midnight, the window of the day before is running
+		{at(7, 0, 30), "night", at(6, 23, 0), at(7, 1, 0)},
+		{at(7, 1, 0), "saturday", at(7, 8, 0), at(7, 11, 0)},
+	} {
+		w := c.NextWindow(tt.now)
+		if w.Slot != tt.slot || !w.Start.Equal(tt.start) || !w.End.Equal(tt.end) {
+			t.Errorf("%v: window %v %v-%v, want %v %v-%v", tt.now,
+				w.Slot, w.Start, w.End, tt.slot, tt.start, tt.end)
+		}
+	}
+	ws := c.Windows(at(1, 0, 0), 8)
+	for i := 1; i < len(ws); i++ {
+		if ws[i].Start.Before(ws[i-1].End) {
+			t.Errorf("window %v starts before the end of %v", ws[i], ws[i-1])
+		}
+	}
+	if len(ws) != 8 {
+		t.Errorf("%v windows, want 8", len(ws))
+	}
+}
+
+func TestCheckOverlap(t *testing.T) {
+	overlaps := func(conf string) []string {
+		var ss []string
+		for _, e := range mustParse(t, "timezone UTC\n"+conf).Check() {
+			if strings.Contains(e.Err.Error(), "overlap") {
+				ss = append(ss, e.Err.Error())
+			}
+		}
+		return ss
+	}
+	for _, tt := range []struct {
+		conf string
+		n    int
+	}{
+		{"slot a sat 8:00AM 3h\nslot b sun 8:00AM 3h\n", 0},
+		{"slot a sat 8:00AM 3h\nslot b sat 11:00AM 1h\n", 0},
+		{"slot a sat 8:00AM 3h\nslot b sat 10:00AM 1h\n", 1},
+		{"slot a daily 8:00AM 3h\nslot b weekends 9:00AM 1h\n", 1},
+		// across midnight
+		{"slot a fri 11:00PM 2h\nslot b sat 12:30AM 1h\n", 1},
+	} {
+		if ss := overlaps(tt.conf); len(ss) != tt.n {
+			t.Errorf("%q: %v overlaps %v, want %v", tt.conf, len(ss), ss, tt.n)
+		}
+	}
+}
+
+func TestDST(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	// Paris moves to summer time on March 29, 2026 at 2:00AM and back
+	// to winter time on October 25, 2026 at 3:00AM
+	for _, tt := range []struct {
+		conf  string
+		now   time.Time
+		start time.Time
+		end   time.Time
+	}{
+		{
+			"slot s daily 8:00AM 1h\n",
+			time.Date(2026, 3, 29, 0, 0, 0, 0, paris),
+			time.Date(2026, 3, 29, 8, 0, 0, 0, paris),
+			time.Date(2026, 3, 29, 9, 0, 0, 0, paris),
+		},
+		{
+			"slot s daily 8:00AM 1h\n",
+			time.Date(2026, 10, 25, 0, 0, 0, 0, paris),
+			time.Date(2026, 10, 25, 8, 0, 0, 0, paris),
+			time.Date(2026, 10, 25, 9, 0, 0, 0, paris),
+		},
+		// the windows last their duration, not until the same time
+		{
+			"slot s sat 11:00PM 4h\n",
+			time.Date(2026, 3, 28, 12, 0, 0, 0, paris),
+			time.Date(2026, 3, 28, 23, 0, 0, 0, paris),
+			time.Date(2026, 3, 29, 4, 0, 0, 0, paris),
+		},
+		{
+			"slot s sat 11:00PM 4h\n",
+			time.Date(2026, 10, 24, 12, 0, 0, 0, paris),
+			time.Date(2026, 10, 24, 23, 0, 0, 0, paris),
+			time.Date(2026, 10, 25, 2, 0, 0, 0, paris),
+		},
+		// 2:30AM does not exist on March 29
+		{
+			"slot s sun 2:30AM 1h\n",
+			time.Date(2026, 3, 29, 0, 0, 0, 0, paris),
+			time.Date(2026, 3, 29, 3, 30, 0, 0, paris),
+			time.Date(2026, 3, 29, 4, 30, 0, 0, paris),
+		},
+		// legacy periods of whole days keep the time of day
+		{
+			"start 8:00AM\neach 48h\nduration 1h\n",
+			time.Date(2026, 3, 28, 12, 0, 0, 0, paris),
+			time.Date(2026, 3, 30, 8, 0, 0, 0, paris),
+			time.Date(2026, 3, 30, 9, 0, 0, 0, paris),
+		},
+	} {
+		c := mustParse(t, "timezone Europe/Paris\n"+tt.conf)
+		// the server runs in another time zone
+		w := c.NextWindow(tt.now.UTC())
+		if !w.Start.Equal(tt.start) || !w.End.Equal(tt.end) {
+			t.Errorf("%q at %v: window %v-%v, want %v-%v", tt.conf, tt.now,
+				w.Start, w.End, tt.start, tt.end)
+		}
+		if w.Start.Location().String() != "Europe/Paris" {
+			t.Errorf("%q: window in %v, want Europe/Paris", tt.conf, w.Start.Location())
+		}
+	}
+}
@@ -2,4 +2,4 @@ module github.com/vonaka/smc_station
 
 go 1.17
 
-require github.com/gorilla/websocket v1.4.2 // indirect
+require github.com/gorilla/websocket v1.4.2
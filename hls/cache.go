@@ -0,0 +1,246 @@
+package hls
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Cache keeps the transcoded files by source file and encoding profile,
+// the least recently used are evicted once it grows beyond max bytes.
+// Each entry is a directory holding a playlist and its segments. The
+// source files are known by their path, size and modification time, not
+// by their content: a file replaced by another one of the same size and
+// time is not noticed.
+type Cache struct {
+	mu  sync.Mutex
+	dir string
+	max int64
+}
+
+// OpenCache returns the cache stored in dir, nil if max is 0.
+func OpenCache(dir string, max int64) *Cache {
+	if max == 0 {
+		return nil
+	}
+	return &Cache{dir: dir, max: max}
+}
+
+// key identifies the output of j: the path, size and modification time
+// of its input and the arguments of ffmpeg (but the threads, they do not
+// change the output).
+func (c *Cache) key(j *Job) (string, error) {
+	h := sha256.New()
+	if j.Input != "" {
+		// the slates have no input
+		fi, err := os.Stat(j.Input)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%v\x00%v\x00%v\x00", j.Input, fi.Size(), fi.ModTime().UnixNano())
+	}
+	o := *j
+	o.Output, o.Threads = "", 0
+	io.WriteString(h, strings.Join(o.args(), "\x00"))
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Transcoder returns a transcoder that looks for the jobs in c before
+// running them with t, and keeps their outputs.
+func (c *Cache) Transcoder(t Transcoder) Transcoder {
+	return cached{c, t}
+}
+
+type cached struct {
+	c *Cache
+	t Transcoder
+}
+
+func (ct cached) Transcode(ctx context.Context, j *Job) error {
+	key, err := ct.c.key(j)
+	if err != nil {
+		return ct.t.Transcode(ctx, j)
+	}
+	if ok, err := ct.c.restore(key, j.Output); err != nil {
+		log.Printf("hls: cache: %v", err)
+	} else if ok {
+		log.Printf("hls: %v is in the cache", j.Input)
+		return nil
+	}
+	if err = ct.t.Transcode(ctx, j); err != nil {
+		return err
+	}
+	if err = ct.c.store(key, j.Output); err != nil {
+		log.Printf("hls: cache: %v", err)
+	}
+	return nil
+}
+
+// has reports whether the output of j is in c.
+func (c *Cache) has(j *Job) bool {
+	if c == nil {
+		return false
+	}
+	key, err := c.key(j)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(c.dir, key, "index.m3u8"))
+	return err == nil
+}
+
+// restore links the entry key to the playlist output, it reports
+// whether the entry exists.
+func (c *Cache) restore(key, output string) (bool, error) {
+	dir := filepath.Join(c.dir, key)
+	base := strings.TrimSuffix(output, filepath.Ext(output))
+	n := 0
+	str, err := rename(filepath.Join(dir, "index.m3u8"), func(seg string) (string, error) {
+		dst := fmt.Sprintf("%v%v.ts", base, n)
+		n++
+		return filepath.Base(dst), link(filepath.Join(dir, seg), dst)
+	})
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+	return true, os.WriteFile(output, []byte(str), 0666)
+}
+
+// store links the playlist output and its segments to the entry key,
+// then evicts what does not fit in c anymore.
+func (c *Cache) store(key, output string) error {
+	if err := os.MkdirAll(c.dir, 0775); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(c.dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	n := 0
+	str, err := rename(output, func(seg string) (string, error) {
+		dst := fmt.Sprintf("%v.ts", n)
+		n++
+		return dst, link(filepath.Join(filepath.Dir(output), seg), filepath.Join(tmp, dst))
+	})
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(filepath.Join(tmp, "index.m3u8"), []byte(str), 0666); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dir := filepath.Join(c.dir, key)
+	if err = os.Rename(tmp, dir); err != nil && !os.IsExist(err) {
+		return err
+	}
+	now := time.Now()
+	os.Chtimes(dir, now, now)
+	return c.evict(key)
+}
+
+// evict removes the least recently used entries, but keep, until c fits
+// in c.max.
+func (c *Cache) evict(keep string) error {
+	ds, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	type entry struct {
+		dir  string
+		size int64
+		used time.Time
+	}
+	var (
+		es    []entry
+		total int64
+	)
+	for _, d := range ds {
+		if !d.IsDir() || strings.HasPrefix(d.Name(), ".") {
+			continue
+		}
+		e := entry{dir: filepath.Join(c.dir, d.Name())}
+		if fi, err := d.Info(); err == nil {
+			e.used = fi.ModTime()
+		}
+		fs, _ := os.ReadDir(e.dir)
+		for _, f := range fs {
+			if fi, err := f.Info(); err == nil {
+				e.size += fi.Size()
+			}
+		}
+		total += e.size
+		if d.Name() != keep {
+			es = append(es, e)
+		}
+	}
+	sort.Slice(es, func(i, j int) bool {
+		return es[i].used.Before(es[j].used)
+	})
+	for _, e := range es {
+		if total <= c.max {
+			break
+		}
+		if err = os.RemoveAll(e.dir); err != nil {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+// rename returns the playlist filename with its segments renamed by f.
+func rename(filename string, f func(seg string) (string, error)) (string, error) {
+	str, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	var out string
+	for _, l := range strings.Split(string(str), "\n") {
+		if l != "" && !strings.HasPrefix(l, "#") {
+			if l, err = f(l); err != nil {
+				return "", err
+			}
+		}
+		if l != "" {
+			out += l + "\n"
+		}
+	}
+	return out, nil
+}
+
+// link makes dst a hard link to src or, if it cannot, a copy of it.
+func link(src, dst string) error {
+	os.Remove(dst)
+	if os.Link(src, dst) == nil {
+		return nil
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
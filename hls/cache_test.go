@@ -0,0 +1,181 @@
+package hls
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// cacheJob returns the job of a 5s file of dir transcoded into the
+// playlist output of dir.
+func cacheJob(t *testing.T, dir, input, output string) *Job {
+	t.Helper()
+	j := &Job{
+		Input:    filepath.Join(dir, input),
+		Output:   filepath.Join(dir, output),
+		Subtitle: -1,
+		Length:   5 * time.Second,
+	}
+	if _, err := os.Stat(j.Input); os.IsNotExist(err) {
+		if err := os.WriteFile(j.Input, []byte(input), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return j
+}
+
+func TestCacheRestore(t *testing.T) {
+	dir := t.TempDir()
+	c := OpenCache(filepath.Join(dir, "cache"), 1<<20)
+	f := &FakeTranscoder{}
+	tr := c.Transcoder(f)
+
+	ctx := context.Background()
+	for _, out := range []string{"first.m3u8", "second.m3u8"} {
+		if err := tr.Transcode(ctx, cacheJob(t, dir, "a.mkv", out)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(f.Jobs) != 1 {
+		t.Fatalf("%v jobs transcoded, want 1", len(f.Jobs))
+	}
+	if !c.has(cacheJob(t, dir, "a.mkv", "third.m3u8")) {
+		t.Errorf("a.mkv is not in the cache")
+	}
+
+	// the restored playlist has its own segments
+	str, err := os.ReadFile(filepath.Join(dir, "second.m3u8"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	segs := 0
+	for _, l := range strings.Split(string(str), "\n") {
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		if !strings.HasPrefix(l, "second") {
+			t.Errorf("segment %v of second.m3u8", l)
+		}
+		if _, err := os.Stat(filepath.Join(dir, l)); err != nil {
+			t.Error(err)
+		}
+		segs++
+	}
+	if segs != 3 {
+		t.Errorf("%v segments in second.m3u8, want 3:\n%s", segs, str)
+	}
+
+	// a modified input is transcoded again
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "a.mkv"), later, later)
+	if err := tr.Transcode(ctx, cacheJob(t, dir, "a.mkv", "third.m3u8")); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Jobs) != 2 {
+		t.Errorf("%v jobs transcoded, want 2", len(f.Jobs))
+	}
+}
+
+func TestCacheStoreExisting(t *testing.T) {
+	dir := t.TempDir()
+	c := OpenCache(filepath.Join(dir, "cache"), 1<<20)
+	j := cacheJob(t, dir, "a.mkv", "out.m3u8")
+	if err := (&FakeTranscoder{}).Transcode(context.Background(), j); err != nil {
+		t.Fatal(err)
+	}
+	key, err := c.key(j)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// two programs transcoding the same file both store it
+	for i := 0; i < 2; i++ {
+		if err := c.store(key, j.Output); err != nil {
+			t.Fatalf("store %v: %v", i, err)
+		}
+	}
+	ds, err := os.ReadDir(c.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ds) != 1 || ds[0].Name() != key {
+		var names []string
+		for _, d := range ds {
+			names = append(names, d.Name())
+		}
+		t.Errorf("cache holds %v, want only %v", names, key)
+	}
+	if ok, err := c.restore(key, filepath.Join(dir, "restored.m3u8")); !ok || err != nil {
+		t.Errorf("restore: %v, %v", ok, err)
+	}
+}
+
+func TestCacheEvict(t *testing.T) {
+	dir := t.TempDir()
+	c := OpenCache(filepath.Join(dir, "cache"), 1<<20)
+	f := &FakeTranscoder{}
+	tr := c.Transcoder(f)
+	ctx := context.Background()
+
+	// the entries are used an hour apart: a, then b
+	used := func(j *Job, ago time.Duration) {
+		t.Helper()
+		key, err := c.key(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		at := time.Now().Add(-ago)
+		if err := os.Chtimes(filepath.Join(c.dir, key), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a, b := cacheJob(t, dir, "a.mkv", "a.m3u8"), cacheJob(t, dir, "b.mkv", "b.m3u8")
+	for i, j := range []*Job{a, b} {
+		if err := tr.Transcode(ctx, j); err != nil {
+			t.Fatal(err)
+		}
+		used(j, time.Duration(2-i)*time.Hour)
+	}
+
+	// room for two entries only, all of the same size
+	key, _ := c.key(a)
+	fs, err := os.ReadDir(filepath.Join(c.dir, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var size int64
+	for _, f := range fs {
+		fi, err := f.Info()
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += fi.Size()
+	}
+	c.max = 2 * size
+
+	// restoring a makes b the least recently used
+	if err := tr.Transcode(ctx, cacheJob(t, dir, "a.mkv", "again.m3u8")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tr.Transcode(ctx, cacheJob(t, dir, "c.mkv", "c.m3u8")); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		input string
+		has   bool
+	}{
+		{"a.mkv", true},
+		{"b.mkv", false},
+		{"c.mkv", true},
+	} {
+		if has := c.has(cacheJob(t, dir, tt.input, "x.m3u8")); has != tt.has {
+			t.Errorf("%v in the cache: %v, want %v", tt.input, has, tt.has)
+		}
+	}
+	if len(f.Jobs) != 3 {
+		t.Errorf("%v jobs transcoded, want 3", len(f.Jobs))
+	}
+}
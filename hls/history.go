@@ -0,0 +1,200 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Airing is an entry of the airing history.
+type Airing struct {
+	File   string    `json:"file"`
+	Series string    `json:"series,omitempty"`
+	Slot   string    `json:"slot,omitempty"`
+	Time   time.Time `json:"time"`
+	// Compacted airings only tell when their file was last aired, see
+	// History.compact.
+	Compacted bool `json:"compacted,omitempty"`
+}
+
+// History is the log of what has been aired, kept as one JSON object
+// per line so that it is only appended to, but when it is compacted.
+type History struct {
+	mu   sync.Mutex
+	path string
+	as   []Airing
+	last map[string]time.Time
+	// kept is the number of airings left by the last compaction.
+	kept int
+}
+
+// LoadHistory reads the history stored at path, the lines that cannot
+// be read are skipped.
+func LoadHistory(path string) *History {
+	h := &History{
+		path: path,
+		last: make(map[string]time.Time),
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return h
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		var a Airing
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		if err := json.Unmarshal(sc.Bytes(), &a); err != nil {
+			log.Printf("history: %v:%v: %v", path, n, err)
+			continue
+		}
+		h.add(a)
+	}
+	if err := sc.Err(); err != nil {
+		log.Printf("history: %v: %v", path, err)
+	}
+	h.kept = len(h.as)
+	return h
+}
+
+func (h *History) add(a Airing) {
+	h.as = append(h.as, a)
+	if a.Time.After(h.last[a.File]) {
+		h.last[a.File] = a.Time
+	}
+}
+
+// Record appends as to h.
+func (h *History) Record(as []Airing) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.path == "" {
+		for _, a := range as {
+			h.add(a)
+		}
+		return nil
+	}
+	f, err := os.OpenFile(h.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, a := range as {
+		str, err := json.Marshal(a)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(str, '\n'))
+		h.add(a)
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// clone returns a copy of h that is kept in memory only.
+func (h *History) clone() *History {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	n := &History{
+		as:   append([]Airing{}, h.as...),
+		last: make(map[string]time.Time, len(h.last)),
+	}
+	for k, v := range h.last {
+		n.last[k] = v
+	}
+	n.kept = len(n.as)
+	return n
+}
+
+// lastAired returns when filename was last aired.
+func (h *History) lastAired(filename string) time.Time {
+	if h == nil {
+		return time.Time{}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last[filename]
+}
+
+// cycle returns the files of lib that have been aired since lib was
+// last aired entirely, and when the first of them was (zero if none
+// was). The compacted airings are left out.
+func (h *History) cycle(lib map[string]bool) (map[string]bool, time.Time) {
+	aired := make(map[string]bool)
+	var start time.Time
+	if h == nil {
+		return aired, start
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, a := range h.as {
+		if !lib[a.File] || a.Compacted {
+			continue
+		}
+		if len(aired) == 0 {
+			start = a.Time
+		}
+		aired[a.File] = true
+		if len(aired) == len(lib) {
+			aired, start = make(map[string]bool), time.Time{}
+		}
+	}
+	return aired, start
+}
+
+// grown reports whether h has doubled since it was last compacted.
+func (h *History) grown() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.as) >= 2*h.kept
+}
+
+// compact drops the airings before `before` but the last one of each
+// file, which is marked as compacted: it only tells when its file was
+// last aired. The file of h is rewritten if anything changes. It
+// returns the number of airings dropped.
+func (h *History) compact(before time.Time) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	last := make(map[string]int, len(h.last))
+	for i, a := range h.as {
+		last[a.File] = i
+	}
+	as := make([]Airing, 0, len(h.last))
+	changed := false
+	for i, a := range h.as {
+		if a.Time.Before(before) {
+			if last[a.File] != i {
+				changed = true
+				continue
+			}
+			changed = changed || !a.Compacted
+			a.Compacted = true
+		}
+		as = append(as, a)
+	}
+	n := len(h.as) - len(as)
+	h.as, h.kept = as, len(as)
+	if !changed || h.path == "" {
+		return n, nil
+	}
+	var buf bytes.Buffer
+	for _, a := range as {
+		str, err := json.Marshal(a)
+		if err != nil {
+			return n, err
+		}
+		buf.Write(append(str, '\n'))
+	}
+	return n, writeFile(h.path, buf.Bytes())
+}
@@ -0,0 +1,144 @@
+package hls
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeHistory writes as in the history file of dir.
+func writeHistory(t *testing.T, dir string, as ...Airing) {
+	t.Helper()
+	var str []byte
+	for _, a := range as {
+		l, err := json.Marshal(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		str = append(append(str, l...), '\n')
+	}
+	if err := os.WriteFile(filepath.Join(dir, "smc.history"), str, 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompactWindow(t *testing.T) {
+	dir := t.TempDir()
+	c := testConfig(t, dir, "slot test daily 8:00AM 1h\nno-repeat 1d\n")
+	a, b := filepath.Join(c.DataDir(), "a.mkv"), filepath.Join(c.DataDir(), "b.mkv")
+	now := time.Now().Truncate(time.Second)
+	writeHistory(t, dir,
+		Airing{File: a, Time: now.Add(-72 * time.Hour)},
+		Airing{File: b, Time: now.Add(-72 * time.Hour)},
+		Airing{File: a, Time: now.Add(-48 * time.Hour)},
+		Airing{File: b, Time: now.Add(-36 * time.Hour)},
+		Airing{File: a, Time: now.Add(-time.Hour)},
+	)
+	testLibrary(t, c, map[string]*MediaInfo{
+		"a.mkv": episode(10 * time.Minute),
+		"b.mkv": episode(10 * time.Minute),
+	})
+
+	h := LoadHistory(c.HistoryFile())
+	if len(h.as) != 2 {
+		t.Fatalf("history %+v, want 2 airings", h.as)
+	}
+	if h.as[0].File != b || !h.as[0].Compacted || h.as[1].File != a || h.as[1].Compacted {
+		t.Errorf("history %+v, want b compacted then a", h.as)
+	}
+	for f, want := range map[string]time.Time{
+		a: now.Add(-time.Hour),
+		b: now.Add(-36 * time.Hour),
+	} {
+		if got := h.lastAired(f); !got.Equal(want) {
+			t.Errorf("%v last aired at %v, want %v", filepath.Base(f), got, want)
+		}
+	}
+}
+
+func TestCompactExhaust(t *testing.T) {
+	dir := t.TempDir()
+	c := testConfig(t, dir, "slot test daily 8:00AM 1h\n")
+	a, b := filepath.Join(c.DataDir(), "a.mkv"), filepath.Join(c.DataDir(), "b.mkv")
+	now := time.Now().Truncate(time.Second)
+	writeHistory(t, dir,
+		Airing{File: a, Time: now.Add(-3 * time.Hour)},
+		Airing{File: b, Time: now.Add(-2 * time.Hour)},
+		Airing{File: a, Time: now.Add(-time.Hour)},
+	)
+	tk := testLibrary(t, c, map[string]*MediaInfo{
+		"a.mkv": episode(10 * time.Minute),
+		"b.mkv": episode(10 * time.Minute),
+	})
+
+	// the cycle is the same once compacted
+	lib := map[string]bool{a: true, b: true}
+	for _, h := range []*History{tk.history, LoadHistory(c.HistoryFile())} {
+		if len(h.as) != 2 {
+			t.Errorf("history %+v, want 2 airings", h.as)
+		}
+		if cycle, _ := h.cycle(lib); len(cycle) != 1 || !cycle[a] {
+			t.Errorf("cycle %v, want a.mkv", cycle)
+		}
+	}
+}
+
+func TestCompactRetention(t *testing.T) {
+	for _, tt := range []struct {
+		conf string
+		want int
+	}{
+		{"no-repeat off\n", 5},
+		{"no-repeat off\nhistory-retention 4d\n", 5},
+		{"no-repeat off\nhistory-retention 50h\n", 3},
+		{"no-repeat 1d\nhistory-retention 50h\n", 3},
+		{"history-retention forever\n", 5},
+	} {
+		dir := t.TempDir()
+		c := testConfig(t, dir, "slot test daily 8:00AM 1h\n"+tt.conf)
+		a, b := filepath.Join(c.DataDir(), "a.mkv"), filepath.Join(c.DataDir(), "b.mkv")
+		now := time.Now().Truncate(time.Second)
+		writeHistory(t, dir,
+			Airing{File: a, Time: now.Add(-72 * time.Hour)},
+			Airing{File: b, Time: now.Add(-72 * time.Hour)},
+			Airing{File: a, Time: now.Add(-48 * time.Hour)},
+			Airing{File: b, Time: now.Add(-36 * time.Hour)},
+			Airing{File: a, Time: now.Add(-time.Hour)},
+		)
+		testLibrary(t, c, map[string]*MediaInfo{
+			"a.mkv": episode(10 * time.Minute),
+			"b.mkv": episode(10 * time.Minute),
+		})
+		if h := LoadHistory(c.HistoryFile()); len(h.as) != tt.want {
+			t.Errorf("%q: history %+v, want %v airings", tt.conf, h.as, tt.want)
+		}
+	}
+}
+
+func TestDryTank(t *testing.T) {
+	dir := t.TempDir()
+	c := testConfig(t, dir, "slot test daily 8:00AM 1h\nno-repeat 30m\n")
+	a := filepath.Join(c.DataDir(), "a.mkv")
+	now := time.Now().Truncate(time.Second)
+	testLibrary(t, c, map[string]*MediaInfo{"a.mkv": episode(10 * time.Minute)})
+	writeHistory(t, dir,
+		Airing{File: a, Time: now.Add(-2 * time.Hour)},
+		Airing{File: a, Time: now.Add(-time.Hour)},
+	)
+	before, err := os.ReadFile(c.HistoryFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tk := &Tank{cached: true, dry: true}
+	tk.load(c)
+	if err := tk.fillChunks(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(c.HistoryFile()); string(after) != string(before) {
+		t.Errorf("the history is rewritten by a dry tank:\n%s\nwas:\n%s", after, before)
+	}
+}
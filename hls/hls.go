@@ -1,65 +1,527 @@
 package hls
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/vonaka/smc_station/config"
 )
 
 type Program struct {
-	tank  *Tank
-	start int
-	end   int
+	tank   *Tank // a snapshot of the library
+	cs     []chunk
+	window config.Window
+	mu     sync.Mutex
+	start  time.Time // when p started to air, if it did
+
+	playlist string          // where p is written
+	done     int             // chunks transcoded
+	encoding map[int]float64 // progress of the chunks being transcoded
+
+	jobs    int // files transcoded in parallel
+	threads int // threads of each transcoding
+
+	buffer    time.Duration // transcoded beyond the first episode before p airs
+	ready     chan struct{}
+	readyOnce sync.Once
+	slates    map[int]time.Duration // aired before the chunks, when late
+	recorded  []bool                // the episodes recorded as aired
 }
 
 var (
-	ErrTankIndex error = errors.New("tank size exceeded")
+	ErrEmptyTank error = errors.New("no media file in the library")
 )
 
+// MakeProgram orders the tank with the strategy of the next broadcast
+// window, puts aside what cannot be aired again yet, packs as many
+// chunks as fit in the window between the ident and the sign-off, once
+// the time of the bumpers and idents is set aside, adds them and fills
+// the rest with filler clips.
 func MakeProgram(c *config.Config) (*Program, error) {
+	return MakeProgramFor(c, c.NextWindow(time.Now()))
+}
+
+// MakeProgramFor makes the program of the window w. With a seed, the
+// program only depends on the config, the library and what has been
+// aired.
+func MakeProgramFor(c *config.Config, w config.Window) (*Program, error) {
+	return MakeProgramAfter(c, w, nil)
+}
+
+// MakeProgramAfter makes the program of the window w as if the episodes
+// of prev, which is on the air, were all aired.
+func MakeProgramAfter(c *config.Config, w config.Window, prev *Program) (*Program, error) {
+	if defaultTank == nil {
+		return nil, ErrEmptyTank
+	}
+	if prev == nil {
+		return defaultTank.program(c, w)
+	}
+	lib := defaultTank.snapshot()
+	t := lib.sandbox()
+	is, as, _ := prev.pending()
+	if err := t.aired(prev.cs, is, as); err != nil {
+		return nil, err
+	}
+	p, err := t.program(c, w)
+	if err != nil {
+		return nil, err
+	}
+	p.tank = lib
+	return p, nil
+}
+
+// sandbox returns a copy of t whose state and history are kept in
+// memory only.
+func (t *Tank) sandbox() *Tank {
+	s := t.snapshot()
+	s.history, s.state, s.cache = s.history.clone(), s.state.clone(), nil
+	s.state.history = s.history
+	return s
+}
+
+// Plan makes the programs of the windows ws, each of them being made as
+// if the previous ones had been aired. Nothing is recorded.
+func Plan(c *config.Config, ws []config.Window) ([]*Program, error) {
+	if defaultTank == nil {
+		return nil, ErrEmptyTank
+	}
+	t := defaultTank.sandbox()
+	var ps []*Program
+	for _, w := range ws {
+		p, err := t.program(c, w)
+		if err != nil {
+			return ps, err
+		}
+		if err = Aired(p, w.Start); err != nil {
+			return ps, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, nil
+}
+
+func (t *Tank) program(c *config.Config, w config.Window) (*Program, error) {
+	// the library may be reloaded while p is transcoded
+	lib := t.snapshot()
+	if len(lib.cs) == 0 {
+		return nil, ErrEmptyTank
+	}
 	p := &Program{
-		tank:  defaultTank,
-		start: 0,
+		tank:    lib,
+		window:  w,
+		jobs:    c.TranscodeJobs(),
+		threads: c.TranscodeThreads(),
+		buffer:  c.Buffer(),
+		ready:   make(chan struct{}),
 	}
-	ShuffleTank()
-	avg := p.tank.ds[len(p.tank.ds)-1].Minutes() / float64(len(p.tank.ds))
-	p.end = int(c.Duration().Minutes() / avg)
-	if p.end == 0 {
-		p.end = 1
+	order := c.OrderOf(p.window)
+	var r *rand.Rand
+	if seed, ok := c.Seed(); ok {
+		r = rand.New(rand.NewSource(seed ^ w.Start.Unix()))
+	} else {
+		r = rand.New(rand.NewSource(rand.Int63()))
 	}
-	if l := len(p.tank.cs); p.end >= l {
-		p.end = l
-	} else if d := p.tank.ds[p.end-1]; d < c.Duration() {
-		for p.end < l && d < c.Duration() {
-			d = p.tank.ds[p.end-1]
-			p.end++
+	cs := strategyOf(order).Order(p.tank.cs, p.tank.state, r)
+	cs, fresh := noRepeat(cs, c.NoRepeat(), p.window.Start, p.tank.history)
+
+	// the ident and the sign-off are kept out of the packed time
+	var head, tail []chunk
+	d := p.window.Duration()
+	ident, hasIdent := c.Interstitial("ident")
+	if hasIdent {
+		head = pickClip(p.tank.clips["ident"], d, r)
+	}
+	if _, ok := c.Interstitial("signoff"); ok {
+		tail = pickClip(p.tank.clips["signoff"], d-length(head), r)
+	}
+	d -= length(head) + length(tail)
+
+	// the time of the bumpers and idents is set aside, it depends on
+	// the number of chunks packed
+	var (
+		ps      []chunk
+		last    int
+		reserve time.Duration
+	)
+	for {
+		ps, last = pack(cs, d-reserve, func(c *chunk) string {
+			if c.sequential || order == "series" {
+				return c.series
+			}
+			return ""
+		})
+		need := interstitials(c, p.tank.clips, len(ps), length(head), d)
+		if need <= reserve || need >= d {
+			break
 		}
+		reserve = need
+	}
+	cs = ps
+	if last > fresh {
+		log.Printf("hls: not enough episodes left, %v of them aired recently",
+			last-fresh)
 	}
+	if bumper, ok := c.Interstitial("bumper"); ok {
+		cs = addBumpers(cs, p.tank.clips["bumper"], bumper.Every, d, r)
+	}
+	if hasIdent && ident.Period > 0 {
+		cs = addIdents(cs, p.tank.clips["ident"], ident.Period, length(head), d, r)
+	}
+	cs = fillGap(cs, p.tank.clips["filler"], d, r)
+	p.cs = append(append(head, cs...), tail...)
 	return p, nil
 }
 
-func (p *Program) Next() error {
-	p.start = p.end
-	p.end *= 2
-	if l := len(p.tank.cs); p.end >= l {
-		return ErrTankIndex
+// noRepeat moves to the end of cs the chunks that should not be aired
+// again yet, according to policy, and returns the number of the others.
+func noRepeat(cs []chunk, policy time.Duration, at time.Time, h *History) ([]chunk, int) {
+	var aired func(c *chunk) bool
+	switch policy {
+	case config.Repeat:
+		return cs, len(cs)
+	case config.Exhaust:
+		lib := make(map[string]bool, len(cs))
+		for _, c := range cs {
+			lib[c.filename] = true
+		}
+		cycle, _ := h.cycle(lib)
+		aired = func(c *chunk) bool {
+			return cycle[c.filename]
+		}
+	default:
+		aired = func(c *chunk) bool {
+			return h.lastAired(c.filename).After(at.Add(-policy))
+		}
+	}
+	var fresh, old []chunk
+	for i := range cs {
+		if aired(&cs[i]) {
+			old = append(old, cs[i])
+		} else {
+			fresh = append(fresh, cs[i])
+		}
+	}
+	sort.SliceStable(old, func(i, j int) bool {
+		return h.lastAired(old[i].filename).Before(h.lastAired(old[j].filename))
+	})
+	return append(fresh, old...), len(fresh)
+}
+
+// pack takes, in order, the chunks of cs that fit in d, then refills
+// the time left (see refill). It returns the chunks taken, in the order
+// of cs, with the number of chunks of cs up to the last one taken. Once
+// a chunk is left aside, the rest of its series is too: episodes never
+// air out of order. If no chunk fits, the first one is trimmed.
+func pack(cs []chunk, d time.Duration, series func(*chunk) string) ([]chunk, int) {
+	var (
+		taken = make([]bool, len(cs))
+		sum   time.Duration
+		aside = make(map[string]bool)
+		n     int
+	)
+	for i := range cs {
+		s := series(&cs[i])
+		if s != "" && aside[s] {
+			continue
+		}
+		if sum+cs[i].duration > d {
+			if s != "" {
+				aside[s] = true
+			}
+			continue
+		}
+		taken[i] = true
+		sum += cs[i].duration
+		n++
+		if sum == d {
+			break
+		}
+	}
+	if n == 0 && len(cs) > 0 {
+		c := cs[0]
+		c.trim = d
+		return []chunk{c}, 1
+	}
+	if sum < d {
+		refill(cs, taken, d-sum, series)
+	}
+	var (
+		ps   []chunk
+		last int
+	)
+	for i := range cs {
+		if taken[i] {
+			ps = append(ps, cs[i])
+			last = i + 1
+		}
+	}
+	return ps, last
+}
+
+// fillPool bounds the number of chunks taken, and of chunks left out,
+// that refill reconsiders.
+const fillPool = 16
+
+// fillCells bounds the size of the subset sum of refill, the durations
+// are rounded up to a second or more so that it is not exceeded.
+const fillCells = 20000
+
+// refill swaps some of the chunks of cs taken by the first pass of pack
+// for some of those left out, to fill more of the time left. It only
+// leaves out the last fillPool chunks taken that are in no series and
+// only takes the first fillPool chunks left out that are in no series
+// or next in theirs. Among them, it keeps the combination that fills
+// the most without exceeding the time left, found by a subset sum, and
+// the earliest chunks of cs among those that fill as much.
+func refill(cs []chunk, taken []bool, left time.Duration, series func(*chunk) string) {
+	var (
+		items []int
+		out   time.Duration // of the chunks taken that may be left out
+	)
+	for i := len(cs) - 1; i >= 0 && len(items) < fillPool; i-- {
+		if taken[i] && series(&cs[i]) == "" {
+			items = append(items, i)
+			out += cs[i].duration
+		}
+	}
+	room := left + out
+	seen := make(map[string]bool)
+	for i, in := 0, 0; i < len(cs) && in < fillPool; i++ {
+		s := series(&cs[i])
+		if taken[i] || (s != "" && seen[s]) {
+			continue
+		}
+		if s != "" {
+			// the episodes before it are taken, the next ones
+			// cannot be
+			seen[s] = true
+		}
+		if d := cs[i].duration; d > 0 && d <= room {
+			items = append(items, i)
+			in++
+		}
+	}
+
+	unit := time.Second
+	if room/unit > fillCells {
+		unit = room / fillCells
+	}
+	// the earlier the chunks, the higher the rank of a combination
+	type fill struct {
+		d    time.Duration
+		rank int
+	}
+	size := int(room / unit)
+	best := make([]fill, size+1)
+	keep := make([][]bool, len(items))
+	for k, i := range items {
+		w := int((cs[i].duration + unit - 1) / unit)
+		keep[k] = make([]bool, size+1)
+		for c := size; c >= w; c-- {
+			v := fill{best[c-w].d + cs[i].duration, best[c-w].rank + len(cs) - i}
+			if v.d > best[c].d || (v.d == best[c].d && v.rank > best[c].rank) {
+				best[c], keep[k][c] = v, true
+			}
+		}
+	}
+	if best[size].d <= out {
+		return
+	}
+	for _, i := range items {
+		taken[i] = false
+	}
+	for k, c := len(items)-1, size; k >= 0; k-- {
+		if keep[k][c] {
+			i := items[k]
+			taken[i] = true
+			c -= int((cs[i].duration + unit - 1) / unit)
+		}
+	}
+}
+
+// maxFillers bounds the number of filler clips of a program.
+const maxFillers = 1000
+
+// fillGap completes the chunks of cs with random fillers so that they
+// last exactly d. The fillers are spread over the breaks that follow
+// each chunk, the last one is trimmed to end on time.
+func fillGap(cs, fillers []chunk, d time.Duration, r *rand.Rand) []chunk {
+	gap := d - length(cs)
+	if len(cs) == 0 || len(fillers) == 0 || gap < time.Second {
+		return cs
+	}
+
+	var fs []chunk
+	for gap >= time.Second && len(fs) < maxFillers {
+		var fit []int
+		for i := range fillers {
+			if fillers[i].duration <= gap {
+				fit = append(fit, i)
+			}
+		}
+		if len(fit) == 0 {
+			f := fillers[r.Intn(len(fillers))]
+			f.trim = gap
+			fs = append(fs, f)
+			break
+		}
+		f := fillers[fit[r.Intn(len(fit))]]
+		fs = append(fs, f)
+		gap -= f.duration
+	}
+
+	breaks := make([][]chunk, len(cs))
+	trimmed := len(fs) > 0 && fs[len(fs)-1].trim > 0
+	n := len(fs)
+	if trimmed {
+		n--
+	}
+	for i := 0; i < n; i++ {
+		b := i % len(breaks)
+		breaks[b] = append(breaks[b], fs[i])
+	}
+	var ps []chunk
+	for i, c := range cs {
+		ps = append(ps, c)
+		ps = append(ps, breaks[i]...)
+	}
+	if trimmed {
+		ps = append(ps, fs[n])
+	}
+	return ps
+}
+
+func length(cs []chunk) time.Duration {
+	d := time.Duration(0)
+	for _, c := range cs {
+		d += c.length()
+	}
+	return d
+}
+
+// pickClip returns a random clip of cs that lasts at most d, if any.
+func pickClip(cs []chunk, d time.Duration, r *rand.Rand) []chunk {
+	var fit []chunk
+	for _, c := range cs {
+		if c.duration <= d {
+			fit = append(fit, c)
+		}
+	}
+	if len(fit) == 0 {
+		return nil
+	}
+	return []chunk{fit[r.Intn(len(fit))]}
+}
+
+// interstitials returns how long the bumpers and idents between n
+// chunks may last at most, the chunks lasting up to d after `offset`.
+func interstitials(c *config.Config, clips map[string][]chunk, n int, offset, d time.Duration) time.Duration {
+	breaks := n - 1
+	if breaks <= 0 {
+		return 0
+	}
+	r := time.Duration(0)
+	if bumper, ok := c.Interstitial("bumper"); ok {
+		r += time.Duration(breaks/bumper.Every) * longest(clips["bumper"])
+	}
+	if ident, ok := c.Interstitial("ident"); ok && ident.Period > 0 {
+		ids := int((offset + d) / ident.Period)
+		if ids > breaks {
+			ids = breaks
+		}
+		r += time.Duration(ids) * longest(clips["ident"])
 	}
-	return nil
+	return r
 }
 
+// longest returns the length of the longest chunk of cs.
+func longest(cs []chunk) time.Duration {
+	m := time.Duration(0)
+	for _, c := range cs {
+		if c.duration > m {
+			m = c.duration
+		}
+	}
+	return m
+}
+
+// addBumpers puts a bumper every `every` breaks between the chunks
+// of cs, as long as they fit in d.
+func addBumpers(cs, bumpers []chunk, every int, d time.Duration, r *rand.Rand) []chunk {
+	gap := d - length(cs)
+	var ps []chunk
+	missed := 0
+	for i, c := range cs {
+		ps = append(ps, c)
+		if i == len(cs)-1 || (i+1)%every != 0 {
+			continue
+		}
+		b := pickClip(bumpers, gap, r)
+		if len(b) == 0 {
+			missed++
+		}
+		ps = append(ps, b...)
+		gap -= length(b)
+	}
+	if missed > 0 {
+		log.Printf("hls: no bumper fits in %v of the breaks", missed)
+	}
+	return ps
+}
+
+// addIdents puts an ident at the first break after each `period` since
+// the start of the program, which begins with chunks lasting `offset`.
+func addIdents(cs, idents []chunk, period, offset, d time.Duration, r *rand.Rand) []chunk {
+	gap := d - length(cs)
+	next, at := period, offset
+	var ps []chunk
+	missed := 0
+	for i, c := range cs {
+		ps = append(ps, c)
+		at += c.length()
+		if i == len(cs)-1 || at < next {
+			continue
+		}
+		id := pickClip(idents, gap, r)
+		if len(id) == 0 {
+			missed++
+		}
+		ps = append(ps, id...)
+		gap -= length(id)
+		at += length(id)
+		for next <= at {
+			next += period
+		}
+	}
+	if missed > 0 {
+		log.Printf("hls: no ident fits in %v of the breaks", missed)
+	}
+	return ps
+}
+
+// CopyWithTime copies the playlist src to dst, starting at sec. The
+// segments stay where they are.
 func CopyWithTime(src, dst string, sec int) error {
 	os.RemoveAll(dst)
 	srcStr, err := os.ReadFile(src)
 	if err != nil {
 		return err
 	}
+	prefix, err := filepath.Rel(filepath.Dir(dst), filepath.Dir(src))
+	if err != nil {
+		return err
+	}
 	dstStr := ""
 	srcLines := strings.Split(string(srcStr), "\n")
 	// TODO: optimize
@@ -68,7 +530,10 @@ func CopyWithTime(src, dst string, sec int) error {
 		if lgth := len(exts); lgth <= 0 {
 			continue
 		} else if len(exts) < 2 {
-			dstStr += exts[0] + "\n"
+			if l != "" && !strings.HasPrefix(l, "#") && prefix != "." {
+				l = filepath.ToSlash(filepath.Join(prefix, l))
+			}
+			dstStr += l + "\n"
 			continue
 		}
 		switch exts[0] {
@@ -86,68 +551,280 @@ func CopyWithTime(src, dst string, sec int) error {
 	return os.WriteFile(dst, []byte(dstStr), 0664)
 }
 
-func (p *Program) Write(filename string) error {
-	cs := p.tank.cs[p.start:p.end]
-	alen := -1
-	str := ""
+// filterEscape escapes s to be used as an option value in a filtergraph.
+func filterEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`)
+	s = r.Replace(s)
+	r = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`)
+	return r.Replace(s)
+}
+
+// job returns the transcoding job of c with alen audio tracks: its audio
+// streams, then silent ones if it has less. Its video stream is the one
+// of the .conf files, if any, or the probed one.
+func (c *chunk) job(output string, alen int) *Job {
+	j := &Job{
+		Input:     c.filename,
+		Output:    output,
+		Video:     c.info.Video.Stream,
+		CopyVideo: c.info.CopyVideo(),
+		Subtitle:  c.subtitle,
+		Length:    c.length(),
+		Trim:      c.trim > 0,
+	}
+	if len(c.videostream) > 0 && c.videostream[0] != j.Video {
+		// the probed stream is the first one that is not a cover,
+		// the codec of the others is not known
+		j.Video, j.CopyVideo = c.videostream[0], false
+	}
+	if c.subtitle >= 0 {
+		j.SubtitleBitmap = c.info.Subtitles[c.subtitle].bitmap()
+	}
+	for _, a := range c.audiostream {
+		if len(j.Audio) == alen {
+			break
+		}
+		j.Audio = append(j.Audio, a)
+		j.Languages = append(j.Languages, c.info.Audio[a].Language)
+	}
+	j.Silent = alen - len(j.Audio)
+	return j
+}
+
+// Write transcodes the chunks of p, p.jobs at a time, into the playlist
+// filename. The chunks are appended to it in order as soon as they are
+// transcoded, p is ready to air once its first episode and p.buffer more
+// are. When the transcoding falls behind the airing, a slate (a filler
+// clip or a black screen) is aired until the next chunk is ready. What is
+// in the cache of the tank is not transcoded again. The first error, or
+// the end of ctx, stops the other transcodings.
+func (p *Program) Write(ctx context.Context, filename string) error {
+	defer p.setReady()
+	p.mu.Lock()
+	p.playlist = filename
+	p.done, p.encoding = 0, make(map[int]float64)
+	p.mu.Unlock()
+	cs := p.cs
+	alen, need := p.audioStreams(), p.need()
 	f := strings.TrimSuffix(filename, filepath.Ext(filename))
-	for _, c := range cs {
-		if alen > len(c.audiostream) || alen == -1 {
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	parts := make([]string, len(cs))
+	for i := range cs {
+		parts[i] = fmt.Sprintf("%v_%v_part.m3u8", f, i)
+	}
+	l := newLive(filename, parts)
+	tr := defaultTranscoder
+	if p.tank.cache != nil {
+		tr = p.tank.cache.Transcoder(tr)
+	}
+	if slate, err := p.slate(ctx, tr, f+"_slate.m3u8", alen); err != nil {
+		log.Printf("hls: no slate: %v", err)
+	} else {
+		stop := make(chan struct{})
+		defer close(stop)
+		go p.watch(l, slate, stop)
+	}
+
+	var (
+		wg   sync.WaitGroup
+		once sync.Once
+		err  error
+		todo = make(chan int)
+	)
+	fail := func(e error) {
+		once.Do(func() {
+			err = e
+			cancel()
+		})
+	}
+	for w := 0; w < p.jobs || w == 0; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range todo {
+				j := cs[i].job(parts[i], alen)
+				j.Threads = p.threads
+				j.Progress = p.progress(i)
+				hit, start := p.tank.cache.has(j), time.Now()
+				err := tr.Transcode(ctx, j)
+				p.mu.Lock()
+				delete(p.encoding, i)
+				if err == nil {
+					p.done++
+				}
+				p.mu.Unlock()
+				if err != nil {
+					fail(err)
+					continue
+				}
+				if !hit {
+					p.tank.state.measured(cs[i].profile(), cs[i].length(), time.Since(start))
+				}
+				n, e := l.finished(i)
+				if e != nil {
+					fail(e)
+				} else if n == len(cs) || l.prepared() >= need {
+					p.setReady()
+				}
+			}
+		}()
+	}
+feed:
+	for i := range cs {
+		select {
+		case todo <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(todo)
+	wg.Wait()
+	if e := p.tank.state.Save(); e != nil {
+		log.Println(e)
+	}
+	if err == nil {
+		err = parent.Err()
+	}
+	return err
+}
+
+// Progress tells how far the transcoding of a program is.
+type Progress struct {
+	Done     int      `json:"done"` // episodes and clips
+	Total    int      `json:"total"`
+	Encoding []Encode `json:"encoding,omitempty"`
+}
+
+// Encode is a transcoding in progress.
+type Encode struct {
+	File    string  `json:"file"`
+	Percent float64 `json:"percent"`
+}
+
+// Progress returns how far the transcoding of p is.
+func (p *Program) Progress() Progress {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	pr := Progress{Done: p.done, Total: len(p.cs)}
+	for i := range p.cs {
+		if pc, ok := p.encoding[i]; ok {
+			pr.Encoding = append(pr.Encoding, Encode{p.cs[i].filename, pc})
+		}
+	}
+	return pr
+}
+
+// progress returns the progress function of the transcoding of the
+// chunk i.
+func (p *Program) progress(i int) func(time.Duration) {
+	p.mu.Lock()
+	p.encoding[i] = 0
+	p.mu.Unlock()
+	l := p.cs[i].length()
+	return func(d time.Duration) {
+		pc := 100.0
+		if d < l {
+			pc = 100 * d.Seconds() / l.Seconds()
+		}
+		p.mu.Lock()
+		p.encoding[i] = pc
+		p.mu.Unlock()
+	}
+}
+
+// audioStreams returns the number of audio tracks of p, the highest
+// among its chunks: the others are padded with silence.
+func (p *Program) audioStreams() int {
+	alen := 0
+	for _, c := range p.cs {
+		if len(c.audiostream) > alen {
 			alen = len(c.audiostream)
 		}
 	}
+	return alen
+}
 
-	for i, c := range cs {
-		ffmpeg := "ffmpeg -hide_banner -loglevel error -i "
-		ffmpeg += "\"" + c.filename + "\""
-		if c.vcodec == "h264" {
-			ffmpeg += " -vcodec copy"
-		} else {
-			ffmpeg += " -crf 17"
-			ffmpeg += " -vcodec h264"
-		}
-		// FIXME: for now assumed only one video stream
-		ffmpeg += fmt.Sprintf(" -map 0:v")
-		ffmpeg += " -acodec aac"
-		// TODO: use all audiostreams ?
-		for j := 0; j < alen; j++ {
-			ffmpeg += fmt.Sprintf(" -map 0:a:%v", c.audiostream[j])
-		}
-		ffmpeg += " -metadata service_name='program'"
-		ffmpeg += " -pix_fmt yuv420p"
-		ffmpeg += " -f hls"
-		ffmpeg += " -hls_list_size 0"
-		ffmpeg += " -hls_segment_type mpegts"
-		part := fmt.Sprintf("%v_%v_part.m3u8", f, i)
-		ffmpeg += " " + part
-		log.Printf("hls: %v\n%v\n", c.filename, ffmpeg)
-		_, err := exec.Command("sh", "-c", ffmpeg).Output()
-		if err != nil {
-			return err
+// need returns how much of p is transcoded before p airs: up to its
+// first episode and p.buffer more.
+func (p *Program) need() time.Duration {
+	need := p.buffer
+	for _, c := range p.cs {
+		need += c.length()
+		if c.kind == "" {
+			break
+		}
+	}
+	return need
+}
+
+// Playlist returns the file p is written to.
+func (p *Program) Playlist() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.playlist
+}
+
+// Ready is closed once p can start to air, or once Write fails.
+func (p *Program) Ready() <-chan struct{} {
+	return p.ready
+}
+
+func (p *Program) setReady() {
+	p.readyOnce.Do(func() {
+		close(p.ready)
+	})
+}
+
+// slateLength is the length of the generated slate.
+const slateLength = 10 * time.Second
+
+// slate transcodes into output, with alen audio tracks, the shortest
+// filler clip or, if there is none, a black screen.
+func (p *Program) slate(ctx context.Context, tr Transcoder, output string, alen int) (*Job, error) {
+	var j *Job
+	for _, c := range p.tank.clips["filler"] {
+		if j == nil || c.length() < j.Length {
+			j = c.job(output, alen)
 		}
+	}
+	if j == nil {
+		j = &Job{Output: output, Subtitle: -1, Length: slateLength, Silent: alen}
+	}
+	j.Threads = p.threads
+	return j, tr.Transcode(ctx, j)
+}
 
-		partStr, err := os.ReadFile(part)
+// watch airs the slate whenever less than its length is left to air,
+// until stop is closed.
+func (p *Program) watch(l *live, slate *Job, stop <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+		}
+		p.mu.Lock()
+		start := p.start
+		p.mu.Unlock()
+		if start.IsZero() {
+			continue
+		}
+		i, ok, err := l.insert(slate.Output, time.Since(start), slate.Length)
 		if err != nil {
-			return err
-		}
-		lines := strings.Split(string(partStr), "\n")
-		for _, l := range lines {
-			if l == "" {
-				continue
-			} else if l == "#EXT-X-ENDLIST" {
-				if i != len(cs)-1 {
-					str += "#EXT-X-DISCONTINUITY\n"
-				} else {
-					str += l + "\n"
-				}
-				continue
-			}
-			if i != 0 && strings.HasPrefix(l, "#EXT") && !strings.HasPrefix(l, "#EXTINF") {
-				continue
+			log.Printf("hls: slate: %v", err)
+		} else if ok {
+			log.Println("hls: transcoding is behind, airing a slate")
+			p.mu.Lock()
+			if p.slates == nil {
+				p.slates = make(map[int]time.Duration)
 			}
-			str += l + "\n"
+			p.slates[i] += slate.Length
+			p.mu.Unlock()
 		}
 	}
-
-	return os.WriteFile(filename, []byte(str), 0666)
 }
@@ -0,0 +1,195 @@
+package hls
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testProgram(t *testing.T, dir string) *Program {
+	t.Helper()
+	c := testConfig(t, dir, "slot test daily 8:00AM 1h\ntranscode-jobs 3\n")
+	tk := testLibrary(t, c, map[string]*MediaInfo{
+		"1.mkv": episode(10 * time.Minute),
+		"2.mkv": episode(5 * time.Minute),
+		"3.mkv": episode(20 * time.Minute),
+		"4.mkv": episode(15 * time.Minute),
+	})
+	p, err := tk.program(c, c.NextWindow(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	tr := &FakeTranscoder{}
+	setTranscoder(t, tr)
+	p := testProgram(t, dir)
+
+	filename := filepath.Join(dir, "program.m3u8")
+	if err := p.Write(context.Background(), filename); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-p.Ready():
+	default:
+		t.Errorf("the written program is not ready")
+	}
+	if pr := p.Progress(); pr.Done != len(p.cs) || pr.Total != len(p.cs) || len(pr.Encoding) > 0 {
+		t.Errorf("progress %+v, want %v done", pr, len(p.cs))
+	}
+	// the slate, then the chunks
+	if len(tr.Jobs) != len(p.cs)+1 {
+		t.Errorf("%v jobs, want %v", len(tr.Jobs), len(p.cs)+1)
+	}
+
+	str := readPlaylist(t, filename)
+	if !strings.HasSuffix(str, "#EXT-X-ENDLIST\n") || strings.Contains(str, "EVENT") {
+		t.Errorf("the playlist is not closed:\n%v", str)
+	}
+	if n := strings.Count(str, "#EXT-X-DISCONTINUITY"); n != len(p.cs)-1 {
+		t.Errorf("%v discontinuities, want %v", n, len(p.cs)-1)
+	}
+	// the parts are in the order of the program
+	part, total := 0, time.Duration(0)
+	for _, l := range strings.Split(str, "\n") {
+		var sec float64
+		switch {
+		case l == "#EXT-X-DISCONTINUITY":
+			part++
+		case strings.HasPrefix(l, "#EXTINF:"):
+			if _, err := fmt.Sscanf(l, "#EXTINF:%f,", &sec); err != nil {
+				t.Fatal(err)
+			}
+			total += time.Duration(sec * float64(time.Second))
+		case l != "" && !strings.HasPrefix(l, "#"):
+			if prefix := fmt.Sprintf("program_%v_part", part); !strings.HasPrefix(l, prefix) {
+				t.Errorf("segment %v in the part %v", l, part)
+			}
+		}
+	}
+	if want := length(p.cs); total.Round(time.Millisecond) != want {
+		t.Errorf("the playlist lasts %v, want %v", total, want)
+	}
+}
+
+func TestWriteFails(t *testing.T) {
+	dir := t.TempDir()
+	boom := errors.New("boom")
+	setTranscoder(t, &FakeTranscoder{Err: boom})
+	p := testProgram(t, dir)
+
+	err := p.Write(context.Background(), filepath.Join(dir, "program.m3u8"))
+	if !errors.Is(err, boom) {
+		t.Errorf("Write returns %v, want %v", err, boom)
+	}
+	select {
+	case <-p.Ready():
+	default:
+		t.Errorf("Ready is not closed once Write fails")
+	}
+}
+
+func TestWriteCancelled(t *testing.T) {
+	dir := t.TempDir()
+	setTranscoder(t, &slowTranscoder{delay: 50 * time.Millisecond})
+	p := testProgram(t, dir)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	err := p.Write(ctx, filepath.Join(dir, "program.m3u8"))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Write returns %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestBumpersFit(t *testing.T) {
+	dir := t.TempDir()
+	c := testConfig(t, dir, "slot test daily 8:00AM 1h\nbumper data/bumpers\n")
+	infos := map[string]*MediaInfo{
+		"bumpers/1.mkv": episode(time.Minute),
+		"bumpers/2.mkv": episode(30 * time.Second),
+	}
+	for i := 0; i < 6; i++ {
+		infos[fmt.Sprintf("%v.mkv", i)] = episode(10 * time.Minute)
+	}
+	tk := testLibrary(t, c, infos)
+	w := c.NextWindow(time.Now())
+	p, err := tk.program(c, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	episodes, bumpers := 0, 0
+	for i, ch := range p.cs {
+		switch ch.kind {
+		case "":
+			episodes++
+			if i > 0 && p.cs[i-1].kind != "bumper" {
+				t.Errorf("no bumper before %v", ch)
+			}
+		case "bumper":
+			bumpers++
+		}
+	}
+	if episodes != 5 || bumpers != 4 {
+		t.Errorf("%v episodes and %v bumpers, want 5 and 4", episodes, bumpers)
+	}
+	if l := length(p.cs); l > w.Duration() {
+		t.Errorf("the program lasts %v, longer than its window", l)
+	}
+}
+
+func TestPack(t *testing.T) {
+	chunks := func(ms ...int) []chunk {
+		cs := make([]chunk, len(ms))
+		for i, m := range ms {
+			cs[i] = chunk{filename: fmt.Sprintf("%v", i), duration: time.Duration(m) * time.Minute}
+		}
+		return cs
+	}
+	names := func(cs []chunk) string {
+		var s []string
+		for _, c := range cs {
+			s = append(s, c.filename)
+		}
+		return strings.Join(s, " ")
+	}
+	noSeries := func(*chunk) string { return "" }
+
+	// first-fit takes 35 alone
+	ps, last := pack(chunks(35, 30, 30), time.Hour, noSeries)
+	if got := names(ps); got != "1 2" || last != 3 {
+		t.Errorf("pack = %v, %v; want 1 2, 3", got, last)
+	}
+	// the order of cs is kept and what first fits stays
+	ps, _ = pack(chunks(20, 35, 25, 15), time.Hour, noSeries)
+	if got := names(ps); got != "0 2 3" {
+		t.Errorf("pack = %v, want 0 2 3", got)
+	}
+	// nothing fits
+	ps, last = pack(chunks(70, 80), time.Hour, noSeries)
+	if len(ps) != 1 || ps[0].filename != "0" || ps[0].length() != time.Hour || last != 1 {
+		t.Errorf("pack = %v (%v), %v; want 0 trimmed to 1h", names(ps), length(ps), last)
+	}
+
+	// episodes of the series s never air out of order
+	cs := chunks(35, 30, 30, 20)
+	cs[0].series, cs[1].series, cs[3].series = "s", "s", "s"
+	series := func(c *chunk) string { return c.series }
+	ps, _ = pack(cs, time.Hour, series)
+	if got := names(ps); got != "0" {
+		t.Errorf("pack = %v, want 0", got)
+	}
+	cs = chunks(20, 35, 40, 5)
+	cs[0].series, cs[2].series, cs[3].series = "s", "s", "s"
+	ps, _ = pack(cs, time.Hour, series)
+	if got := names(ps); got != "0 2" {
+		t.Errorf("pack = %v, want 0 2", got)
+	}
+}
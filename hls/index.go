@@ -0,0 +1,115 @@
+package hls
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// Index is the on-disk cache of probe results. An entry is valid as
+// long as the size and the modification time of its file are unchanged.
+type Index struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*indexEntry
+	seen    map[string]struct{}
+}
+
+type indexEntry struct {
+	Size    int64      `json:"size"`
+	ModTime time.Time  `json:"mtime"`
+	Info    *MediaInfo `json:"info,omitempty"`
+	Err     string     `json:"error,omitempty"`
+}
+
+type indexFile struct {
+	Version int                    `json:"version"`
+	Entries map[string]*indexEntry `json:"entries"`
+}
+
+const indexVersion = 2
+
+// LoadIndex reads the index stored at path. A missing, outdated or
+// corrupted index is replaced by an empty one.
+func LoadIndex(path string) *Index {
+	ix := &Index{
+		path:    path,
+		entries: make(map[string]*indexEntry),
+		seen:    make(map[string]struct{}),
+	}
+	str, err := os.ReadFile(path)
+	if err != nil {
+		return ix
+	}
+	var f indexFile
+	if err := json.Unmarshal(str, &f); err != nil || f.Version != indexVersion {
+		return ix
+	}
+	if f.Entries != nil {
+		ix.entries = f.Entries
+	}
+	return ix
+}
+
+// RemoveIndex removes the index stored at path, the library will be
+// entirely probed on the next scan.
+func RemoveIndex(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (ix *Index) lookup(filename string, info os.FileInfo) (*indexEntry, bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.seen[filename] = struct{}{}
+	e, ok := ix.entries[filename]
+	if !ok || e.Size != info.Size() || !e.ModTime.Equal(info.ModTime()) {
+		return nil, false
+	}
+	return e, true
+}
+
+func (ix *Index) store(filename string, info os.FileInfo, e *indexEntry) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	e.Size = info.Size()
+	e.ModTime = info.ModTime()
+	ix.entries[filename] = e
+	ix.seen[filename] = struct{}{}
+}
+
+// prune drops the entries that were not looked up since the last
+// prune and returns their number.
+func (ix *Index) prune() int {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	n := 0
+	for f := range ix.entries {
+		if _, ok := ix.seen[f]; !ok {
+			delete(ix.entries, f)
+			n++
+		}
+	}
+	ix.seen = make(map[string]struct{})
+	return n
+}
+
+func (ix *Index) Len() int {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	return len(ix.entries)
+}
+
+func (ix *Index) Save() error {
+	ix.mu.Lock()
+	str, err := json.Marshal(indexFile{indexVersion, ix.entries})
+	ix.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFile(ix.path, str)
+}
@@ -0,0 +1,124 @@
+package hls
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// live is a playlist that grows as its parts are transcoded. It is an
+// EVENT playlist until its last part is appended.
+type live struct {
+	mu       sync.Mutex
+	filename string
+	parts    []string // the playlists of the parts, in order
+	done     []bool
+	next     int           // the first part not appended yet
+	target   int           // the longest segment, in seconds
+	body     string        // the appended segments
+	length   time.Duration // how long the appended segments last
+	closed   bool
+}
+
+func newLive(filename string, parts []string) *live {
+	return &live{
+		filename: filename,
+		parts:    parts,
+		done:     make([]bool, len(parts)),
+	}
+}
+
+// finished marks the part i as transcoded and appends the parts that
+// can now be, in order. It returns how many parts are appended.
+func (l *live) finished(i int) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.done[i] = true
+	n := l.next
+	for l.next < len(l.parts) && l.done[l.next] {
+		if err := l.append(l.parts[l.next]); err != nil {
+			return l.next, err
+		}
+		l.next++
+	}
+	l.closed = l.next == len(l.parts)
+	if n == l.next {
+		return l.next, nil
+	}
+	return l.next, l.write()
+}
+
+// insert appends part out of order if less than ahead of the playlist
+// is left to air at `edge`. It reports whether part is appended and
+// before which part.
+func (l *live) insert(part string, edge, ahead time.Duration) (int, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed || l.length-edge >= ahead {
+		return l.next, false, nil
+	}
+	if err := l.append(part); err != nil {
+		return l.next, false, err
+	}
+	return l.next, true, l.write()
+}
+
+// prepared returns how long the appended segments last.
+func (l *live) prepared() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.length
+}
+
+// append adds the segments of the playlist part, separated from the
+// previous ones by a discontinuity.
+func (l *live) append(part string) error {
+	str, err := os.ReadFile(part)
+	if err != nil {
+		return err
+	}
+	if l.body != "" {
+		l.body += "#EXT-X-DISCONTINUITY\n"
+	}
+	for _, line := range strings.Split(string(str), "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "#EXTINF:") {
+			d := strings.TrimPrefix(line, "#EXTINF:")
+			if i := strings.Index(d, ","); i >= 0 {
+				d = d[:i]
+			}
+			if sec, err := strconv.ParseFloat(d, 64); err == nil {
+				l.length += time.Duration(sec * float64(time.Second))
+				if t := int(math.Ceil(sec)); t > l.target {
+					l.target = t
+				}
+			}
+		} else if strings.HasPrefix(line, "#EXT") {
+			continue
+		}
+		l.body += line + "\n"
+	}
+	return nil
+}
+
+// write atomically replaces the playlist, the web server may be
+// reading it.
+func (l *live) write() error {
+	str := "#EXTM3U\n#EXT-X-VERSION:3\n"
+	if !l.closed {
+		str += "#EXT-X-PLAYLIST-TYPE:EVENT\n"
+	}
+	str += fmt.Sprintf("#EXT-X-TARGETDURATION:%v\n", l.target)
+	str += "#EXT-X-MEDIA-SEQUENCE:0\n"
+	str += l.body
+	if l.closed {
+		str += "#EXT-X-ENDLIST\n"
+	}
+	return writeFile(l.filename, []byte(str))
+}
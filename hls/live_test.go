@@ -0,0 +1,121 @@
+package hls
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writePart writes the playlist of a part made of segments lasting ds.
+func writePart(t *testing.T, filename string, ds ...float64) {
+	t.Helper()
+	str := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n#EXT-X-MEDIA-SEQUENCE:0\n"
+	base := strings.TrimSuffix(filepath.Base(filename), ".m3u8")
+	for i, d := range ds {
+		str += fmt.Sprintf("#EXTINF:%.6f,\n%v%v.ts\n", d, base, i)
+	}
+	str += "#EXT-X-ENDLIST\n"
+	if err := os.WriteFile(filename, []byte(str), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readPlaylist(t *testing.T, filename string) string {
+	t.Helper()
+	str, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(str)
+}
+
+func TestLive(t *testing.T) {
+	dir := t.TempDir()
+	parts := make([]string, 3)
+	for i := range parts {
+		parts[i] = filepath.Join(dir, fmt.Sprintf("p%v.m3u8", i))
+	}
+	writePart(t, parts[0], 4, 2.5)
+	writePart(t, parts[1], 6)
+	writePart(t, parts[2], 3, 3, 1)
+	filename := filepath.Join(dir, "program.m3u8")
+	l := newLive(filename, parts)
+
+	// the parts are appended in order
+	if n, err := l.finished(1); err != nil || n != 0 {
+		t.Fatalf("finished(1) = %v, %v; want 0, nil", n, err)
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("the playlist is written before its first part")
+	}
+	if n, err := l.finished(0); err != nil || n != 2 {
+		t.Fatalf("finished(0) = %v, %v; want 2, nil", n, err)
+	}
+	str := readPlaylist(t, filename)
+	if !strings.Contains(str, "#EXT-X-PLAYLIST-TYPE:EVENT\n") {
+		t.Errorf("an unfinished playlist is not an EVENT:\n%v", str)
+	}
+	if strings.Contains(str, "#EXT-X-ENDLIST") {
+		t.Errorf("an unfinished playlist ends:\n%v", str)
+	}
+	want := "#EXTINF:4.000000,\np00.ts\n#EXTINF:2.500000,\np01.ts\n" +
+		"#EXT-X-DISCONTINUITY\n#EXTINF:6.000000,\np10.ts\n"
+	if !strings.HasSuffix(str, want) {
+		t.Errorf("playlist:\n%v\nwant it to end with:\n%v", str, want)
+	}
+	if d := l.prepared(); d != 12500*time.Millisecond {
+		t.Errorf("%v prepared, want 12.5s", d)
+	}
+
+	if n, err := l.finished(2); err != nil || n != 3 {
+		t.Fatalf("finished(2) = %v, %v; want 3, nil", n, err)
+	}
+	str = readPlaylist(t, filename)
+	if strings.Contains(str, "EVENT") || !strings.HasSuffix(str, "p22.ts\n#EXT-X-ENDLIST\n") {
+		t.Errorf("the finished playlist is not closed:\n%v", str)
+	}
+	if n := strings.Count(str, "#EXT-X-DISCONTINUITY"); n != 2 {
+		t.Errorf("%v discontinuities, want 2", n)
+	}
+	if !strings.Contains(str, "#EXT-X-TARGETDURATION:6\n") {
+		t.Errorf("the target duration is not the longest segment:\n%v", str)
+	}
+	if strings.Count(str, "#EXTM3U") != 1 || strings.Count(str, "#EXT-X-ENDLIST") != 1 {
+		t.Errorf("the headers of the parts are kept:\n%v", str)
+	}
+}
+
+func TestLiveInsert(t *testing.T) {
+	dir := t.TempDir()
+	parts := []string{filepath.Join(dir, "p0.m3u8"), filepath.Join(dir, "p1.m3u8")}
+	writePart(t, parts[0], 10)
+	writePart(t, parts[1], 10)
+	slate := filepath.Join(dir, "slate.m3u8")
+	writePart(t, slate, 5)
+	l := newLive(filepath.Join(dir, "program.m3u8"), parts)
+	if _, err := l.finished(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// enough is left to air
+	if _, ok, err := l.insert(slate, 2*time.Second, 5*time.Second); err != nil || ok {
+		t.Fatalf("insert with 8s left = %v, %v; want false, nil", ok, err)
+	}
+	i, ok, err := l.insert(slate, 6*time.Second, 5*time.Second)
+	if err != nil || !ok || i != 1 {
+		t.Fatalf("insert with 4s left = %v, %v, %v; want 1, true, nil", i, ok, err)
+	}
+	if _, err := l.finished(1); err != nil {
+		t.Fatal(err)
+	}
+	str := readPlaylist(t, l.filename)
+	if !strings.Contains(str, "p00.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:5.000000,\nslate0.ts\n#EXT-X-DISCONTINUITY\n#EXTINF:10.000000,\np10.ts\n") {
+		t.Errorf("the slate is not between the parts:\n%v", str)
+	}
+	if _, ok, _ := l.insert(slate, time.Hour, 5*time.Second); ok {
+		t.Errorf("a slate is inserted in a closed playlist")
+	}
+}
@@ -0,0 +1,266 @@
+package hls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaInfo is what ffprobe tells about a media file.
+type MediaInfo struct {
+	Duration  time.Duration    `json:"duration"`
+	Format    string           `json:"format"`
+	Video     VideoStream      `json:"video"`
+	Audio     []AudioStream    `json:"audio,omitempty"`
+	Subtitles []SubtitleStream `json:"subtitles,omitempty"`
+	Tags      Tags             `json:"tags"`
+}
+
+// Stream numbers are relative to the streams of the same type,
+// as in `-map 0:a:1`.
+
+type VideoStream struct {
+	Stream    int     `json:"stream"`
+	Codec     string  `json:"codec"`
+	Width     int     `json:"width,omitempty"`
+	Height    int     `json:"height,omitempty"`
+	FrameRate float64 `json:"fps,omitempty"`
+	PixFmt    string  `json:"pix_fmt,omitempty"`
+}
+
+type AudioStream struct {
+	Stream   int    `json:"stream"`
+	Codec    string `json:"codec"`
+	Channels int    `json:"channels"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+}
+
+type SubtitleStream struct {
+	Stream   int    `json:"stream"`
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+	Forced   bool   `json:"forced,omitempty"`
+}
+
+// Tags are the container tags, if any.
+type Tags struct {
+	Title   string `json:"title,omitempty"`
+	Show    string `json:"show,omitempty"`
+	Season  int    `json:"season,omitempty"`
+	Episode int    `json:"episode,omitempty"`
+}
+
+type ffprobeOutput struct {
+	Streams []struct {
+		CodecName   string            `json:"codec_name"`
+		CodecType   string            `json:"codec_type"`
+		Width       int               `json:"width"`
+		Height      int               `json:"height"`
+		PixFmt      string            `json:"pix_fmt"`
+		FrameRate   string            `json:"avg_frame_rate"`
+		RFrameRate  string            `json:"r_frame_rate"`
+		Channels    int               `json:"channels"`
+		Duration    string            `json:"duration"`
+		Tags        map[string]string `json:"tags"`
+		Disposition map[string]int    `json:"disposition"`
+	} `json:"streams"`
+	Format struct {
+		FormatName string            `json:"format_name"`
+		Duration   string            `json:"duration"`
+		Tags       map[string]string `json:"tags"`
+	} `json:"format"`
+}
+
+var (
+	ErrNoDuration error = errors.New("unknown duration")
+	ErrNoStream   error = errors.New("no stream")
+)
+
+// ProbeError is a failure of ffprobe on a file: it exits with an error
+// or tells nothing useful. Unlike the other errors of Probe (ffprobe is
+// missing, the file cannot be read for now), probing the file again
+// gives the same result.
+type ProbeError struct {
+	Err error
+}
+
+func (e *ProbeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ProbeError) Unwrap() error {
+	return e.Err
+}
+
+// Probe runs ffprobe on filename.
+func Probe(ctx context.Context, filename string) (*MediaInfo, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe", "-v", "error",
+		"-show_streams", "-show_format",
+		"-of", "json",
+		filename).Output()
+	if ctx.Err() != nil {
+		// ffprobe is killed
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		var eerr *exec.ExitError
+		if !errors.As(err, &eerr) {
+			return nil, err
+		}
+		if len(eerr.Stderr) > 0 {
+			err = errors.New(strings.TrimSpace(string(eerr.Stderr)))
+		}
+		return nil, &ProbeError{err}
+	}
+	info, err := parseProbe(out)
+	if err != nil {
+		return nil, &ProbeError{err}
+	}
+	return info, nil
+}
+
+func parseProbe(out []byte) (*MediaInfo, error) {
+	var o ffprobeOutput
+	if err := json.Unmarshal(out, &o); err != nil {
+		return nil, err
+	}
+	if len(o.Streams) == 0 {
+		return nil, ErrNoStream
+	}
+
+	info := &MediaInfo{
+		Format: o.Format.FormatName,
+		Video: VideoStream{
+			Codec: "none",
+		},
+	}
+	info.Duration = seconds(o.Format.Duration)
+	hasVideo := false
+	var vs, as, ss int
+	for _, s := range o.Streams {
+		tags := lowerKeys(s.Tags)
+		if d := seconds(s.Duration); d > info.Duration && o.Format.Duration == "" {
+			info.Duration = d
+		}
+		switch s.CodecType {
+		case "video":
+			// cover arts are video streams too
+			if !hasVideo && s.Disposition["attached_pic"] == 0 {
+				hasVideo = true
+				fps := rate(s.FrameRate)
+				if fps == 0 {
+					fps = rate(s.RFrameRate)
+				}
+				info.Video = VideoStream{
+					Stream:    vs,
+					Codec:     s.CodecName,
+					Width:     s.Width,
+					Height:    s.Height,
+					FrameRate: fps,
+					PixFmt:    s.PixFmt,
+				}
+			}
+			vs++
+		case "audio":
+			info.Audio = append(info.Audio, AudioStream{
+				Stream:   as,
+				Codec:    s.CodecName,
+				Channels: s.Channels,
+				Language: tags["language"],
+				Title:    tags["title"],
+				Default:  s.Disposition["default"] != 0,
+			})
+			as++
+		case "subtitle":
+			info.Subtitles = append(info.Subtitles, SubtitleStream{
+				Stream:   ss,
+				Codec:    s.CodecName,
+				Language: tags["language"],
+				Title:    tags["title"],
+				Default:  s.Disposition["default"] != 0,
+				Forced:   s.Disposition["forced"] != 0,
+			})
+			ss++
+		}
+	}
+	if info.Duration <= 0 {
+		return nil, ErrNoDuration
+	}
+
+	tags := lowerKeys(o.Format.Tags)
+	info.Tags = Tags{
+		Title:   tags["title"],
+		Show:    tags["show"],
+		Season:  leadingInt(firstOf(tags, "season_number", "season")),
+		Episode: leadingInt(firstOf(tags, "episode_sort", "episode_id", "episode")),
+	}
+	return info, nil
+}
+
+// CopyVideo tells whether the video stream can be used as is,
+// browsers only play 8-bit 4:2:0 h264.
+func (i *MediaInfo) CopyVideo() bool {
+	switch i.Video.PixFmt {
+	case "", "yuv420p", "yuvj420p":
+		return i.Video.Codec == "h264"
+	}
+	return false
+}
+
+func seconds(s string) time.Duration {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(f * float64(time.Second))
+}
+
+func rate(r string) float64 {
+	nd := strings.SplitN(r, "/", 2)
+	n, err := strconv.ParseFloat(nd[0], 64)
+	if err != nil {
+		return 0
+	}
+	if len(nd) == 1 {
+		return n
+	}
+	d, err := strconv.ParseFloat(nd[1], 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+func lowerKeys(m map[string]string) map[string]string {
+	l := make(map[string]string, len(m))
+	for k, v := range m {
+		l[strings.ToLower(k)] = v
+	}
+	return l
+}
+
+func firstOf(m map[string]string, keys ...string) string {
+	for _, k := range keys {
+		if v, ok := m[k]; ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func leadingInt(s string) int {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, _ := strconv.Atoi(s[:i])
+	return n
+}
@@ -0,0 +1,324 @@
+package hls
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Outputs of `ffprobe -v error -show_streams -show_format -of json`,
+// trimmed of most of the fields parseProbe does not read.
+
+// a Matroska file with a cover art first, a variable frame rate and
+// upper-case tags
+const probeMKV = `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "mjpeg",
+            "codec_long_name": "Motion JPEG",
+            "codec_type": "video",
+            "width": 600,
+            "height": 900,
+            "pix_fmt": "yuvj420p",
+            "r_frame_rate": "90000/1",
+            "avg_frame_rate": "0/0",
+            "disposition": {
+                "default": 0,
+                "forced": 0,
+                "attached_pic": 1
+            },
+            "tags": {
+                "FILENAME": "cover.jpg",
+                "MIMETYPE": "image/jpeg"
+            }
+        },
+        {
+            "index": 1,
+            "codec_name": "hevc",
+            "codec_long_name": "H.265 / HEVC (High Efficiency Video Coding)",
+            "profile": "Main 10",
+            "codec_type": "video",
+            "width": 1920,
+            "height": 1080,
+            "pix_fmt": "yuv420p10le",
+            "r_frame_rate": "24000/1001",
+            "avg_frame_rate": "0/0",
+            "disposition": {
+                "default": 1,
+                "forced": 0,
+                "attached_pic": 0
+            },
+            "tags": {
+                "BPS": "2408012",
+                "DURATION": "00:23:40.012000000"
+            }
+        },
+        {
+            "index": 2,
+            "codec_name": "opus",
+            "codec_long_name": "Opus (Opus Interactive Audio Codec)",
+            "codec_type": "audio",
+            "sample_rate": "48000",
+            "channels": 2,
+            "channel_layout": "stereo",
+            "disposition": {
+                "default": 1,
+                "forced": 0,
+                "attached_pic": 0
+            },
+            "tags": {
+                "LANGUAGE": "jpn",
+                "TITLE": "Japanese"
+            }
+        },
+        {
+            "index": 3,
+            "codec_name": "eac3",
+            "codec_long_name": "ATSC A/52B (AC-3, E-AC-3)",
+            "codec_type": "audio",
+            "sample_rate": "48000",
+            "channels": 6,
+            "channel_layout": "5.1(side)",
+            "disposition": {
+                "default": 0,
+                "forced": 0,
+                "attached_pic": 0
+            },
+            "tags": {
+                "language": "eng"
+            }
+        },
+        {
+            "index": 4,
+            "codec_name": "ass",
+            "codec_long_name": "ASS (Advanced SSA) subtitle",
+            "codec_type": "subtitle",
+            "disposition": {
+                "default": 0,
+                "forced": 1,
+                "attached_pic": 0
+            },
+            "tags": {
+                "Language": "eng",
+                "Title": "Signs"
+            }
+        }
+    ],
+    "format": {
+        "filename": "Show - S02E05.mkv",
+        "nb_streams": 5,
+        "format_name": "matroska,webm",
+        "format_long_name": "Matroska / WebM",
+        "start_time": "0.000000",
+        "duration": "1420.012000",
+        "size": "446792001",
+        "tags": {
+            "TITLE": "The Pilot",
+            "SEASON": "2",
+            "EPISODE": "05 - The Pilot",
+            "ENCODER": "libebml v1.4.2 + libmatroska v1.6.4"
+        }
+    }
+}`
+
+// an iTunes MPEG-4 file with the cover art last
+const probeMP4 = `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "h264",
+            "codec_long_name": "H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10",
+            "profile": "High",
+            "codec_type": "video",
+            "width": 1280,
+            "height": 720,
+            "pix_fmt": "yuv420p",
+            "r_frame_rate": "25/1",
+            "avg_frame_rate": "25/1",
+            "duration": "2640.000000",
+            "disposition": {
+                "default": 1,
+                "forced": 0,
+                "attached_pic": 0
+            },
+            "tags": {
+                "language": "und",
+                "handler_name": "VideoHandler"
+            }
+        },
+        {
+            "index": 1,
+            "codec_name": "aac",
+            "codec_long_name": "AAC (Advanced Audio Coding)",
+            "profile": "LC",
+            "codec_type": "audio",
+            "sample_rate": "48000",
+            "channels": 2,
+            "duration": "2640.021333",
+            "disposition": {
+                "default": 1,
+                "forced": 0,
+                "attached_pic": 0
+            },
+            "tags": {
+                "language": "fra",
+                "handler_name": "SoundHandler"
+            }
+        },
+        {
+            "index": 2,
+            "codec_name": "png",
+            "codec_long_name": "PNG (Portable Network Graphics) image",
+            "codec_type": "video",
+            "width": 1400,
+            "height": 1400,
+            "pix_fmt": "rgb24",
+            "r_frame_rate": "90000/1",
+            "avg_frame_rate": "0/0",
+            "disposition": {
+                "default": 0,
+                "forced": 0,
+                "attached_pic": 1
+            }
+        }
+    ],
+    "format": {
+        "filename": "episode.m4v",
+        "nb_streams": 3,
+        "format_name": "mov,mp4,m4a,3gp,3g2,mj2",
+        "format_long_name": "QuickTime / MOV",
+        "start_time": "0.000000",
+        "duration": "2640.021333",
+        "size": "735914280",
+        "tags": {
+            "major_brand": "M4V ",
+            "title": "Le Retour",
+            "show": "La Série",
+            "season_number": "3",
+            "episode_sort": "7",
+            "episode_id": "S03E07",
+            "media_type": "10"
+        }
+    }
+}`
+
+// an MPEG-TS recording whose container tells no duration
+const probeTS = `{
+    "streams": [
+        {
+            "index": 0,
+            "codec_name": "mpeg2video",
+            "codec_long_name": "MPEG-2 video",
+            "profile": "Main",
+            "codec_type": "video",
+            "width": 720,
+            "height": 576,
+            "pix_fmt": "yuv420p",
+            "r_frame_rate": "25/1",
+            "avg_frame_rate": "25/1",
+            "duration": "1799.880000",
+            "disposition": {
+                "default": 0,
+                "forced": 0,
+                "attached_pic": 0
+            }
+        },
+        {
+            "index": 1,
+            "codec_name": "mp2",
+            "codec_long_name": "MP2 (MPEG audio layer 2)",
+            "codec_type": "audio",
+            "sample_rate": "48000",
+            "channels": 2,
+            "duration": "1800.024000",
+            "disposition": {
+                "default": 0,
+                "forced": 0,
+                "attached_pic": 0
+            },
+            "tags": {
+                "language": "deu"
+            }
+        }
+    ],
+    "format": {
+        "filename": "recording.ts",
+        "nb_streams": 2,
+        "format_name": "mpegts",
+        "format_long_name": "MPEG-TS (MPEG-2 Transport Stream)",
+        "start_time": "1.400000"
+    }
+}`
+
+func TestParseProbe(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		out  string
+		want *MediaInfo
+	}{
+		{"mkv", probeMKV, &MediaInfo{
+			Duration: 1420012 * time.Millisecond,
+			Format:   "matroska,webm",
+			Video: VideoStream{
+				Stream: 1, Codec: "hevc", Width: 1920, Height: 1080,
+				FrameRate: 24000.0 / 1001, PixFmt: "yuv420p10le",
+			},
+			Audio: []AudioStream{
+				{Stream: 0, Codec: "opus", Channels: 2, Language: "jpn", Title: "Japanese", Default: true},
+				{Stream: 1, Codec: "eac3", Channels: 6, Language: "eng"},
+			},
+			Subtitles: []SubtitleStream{
+				{Stream: 0, Codec: "ass", Language: "eng", Title: "Signs", Forced: true},
+			},
+			Tags: Tags{Title: "The Pilot", Season: 2, Episode: 5},
+		}},
+		{"mp4", probeMP4, &MediaInfo{
+			Duration: 2640021333 * time.Microsecond,
+			Format:   "mov,mp4,m4a,3gp,3g2,mj2",
+			Video: VideoStream{
+				Stream: 0, Codec: "h264", Width: 1280, Height: 720,
+				FrameRate: 25, PixFmt: "yuv420p",
+			},
+			Audio: []AudioStream{
+				{Stream: 0, Codec: "aac", Channels: 2, Language: "fra", Default: true},
+			},
+			Tags: Tags{Title: "Le Retour", Show: "La Série", Season: 3, Episode: 7},
+		}},
+		{"ts", probeTS, &MediaInfo{
+			Duration: 1800024 * time.Millisecond,
+			Format:   "mpegts",
+			Video: VideoStream{
+				Stream: 0, Codec: "mpeg2video", Width: 720, Height: 576,
+				FrameRate: 25, PixFmt: "yuv420p",
+			},
+			Audio: []AudioStream{
+				{Stream: 0, Codec: "mp2", Channels: 2, Language: "deu"},
+			},
+		}},
+	} {
+		info, err := parseProbe([]byte(tt.out))
+		if err != nil {
+			t.Errorf("%v: %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(info, tt.want) {
+			t.Errorf("%v: %+v, want %+v", tt.name, info, tt.want)
+		}
+	}
+}
+
+func TestParseProbeErrors(t *testing.T) {
+	for _, tt := range []struct {
+		out string
+		err error
+	}{
+		{`{"streams": [], "format": {"duration": "12.0"}}`, ErrNoStream},
+		{`{"streams": [{"codec_type": "audio"}], "format": {}}`, ErrNoDuration},
+		{`{"streams": [{"codec_type": "audio", "duration": "N/A"}], "format": {}}`, ErrNoDuration},
+	} {
+		if _, err := parseProbe([]byte(tt.out)); err != tt.err {
+			t.Errorf("%v: error %v, want %v", tt.out, err, tt.err)
+		}
+	}
+}
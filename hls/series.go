@@ -0,0 +1,177 @@
+package hls
+
+import (
+	"math/rand"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var (
+	// S01E02, s1e2, 1x02
+	episodeRe = regexp.MustCompile(`(?i)(?:s(\d{1,3})[ ._-]?e(\d{1,4})|\b(\d{1,2})x(\d{2,4})\b)`)
+)
+
+// episodeNumber returns the season and the episode of c, if known.
+func episodeNumber(c *chunk) (int, int, bool) {
+	m := episodeRe.FindStringSubmatch(filepath.Base(c.filename))
+	if m != nil {
+		if m[1] == "" {
+			m[1], m[2] = m[3], m[4]
+		}
+		s, _ := strconv.Atoi(m[1])
+		e, _ := strconv.Atoi(m[2])
+		return s, e, true
+	}
+	if c.info != nil && c.info.Tags.Episode > 0 {
+		return c.info.Tags.Season, c.info.Tags.Episode, true
+	}
+	return 0, 0, false
+}
+
+// sortEpisodes sorts the episodes of a series by season and episode
+// if all of them are numbered, by natural order of their paths otherwise.
+func sortEpisodes(cs []chunk) {
+	type num struct{ s, e int }
+	nums := make(map[string]num, len(cs))
+	numbered := true
+	for i := range cs {
+		s, e, ok := episodeNumber(&cs[i])
+		numbered = numbered && ok
+		nums[cs[i].filename] = num{s, e}
+	}
+	sort.SliceStable(cs, func(i, j int) bool {
+		if numbered {
+			a, b := nums[cs[i].filename], nums[cs[j].filename]
+			if a.s != b.s {
+				return a.s < b.s
+			}
+			if a.e != b.e {
+				return a.e < b.e
+			}
+		}
+		return naturalLess(cs[i].filename, cs[j].filename)
+	})
+}
+
+// naturalLess compares a and b, numbers being compared by value:
+// "ep 2" comes before "ep 10".
+func naturalLess(a, b string) bool {
+	for a != "" && b != "" {
+		da, db := unicode.IsDigit(rune(a[0])), unicode.IsDigit(rune(b[0]))
+		if da && db {
+			na, ra := splitDigits(a)
+			nb, rb := splitDigits(b)
+			ta := strings.TrimLeft(na, "0")
+			tb := strings.TrimLeft(nb, "0")
+			if len(ta) != len(tb) {
+				return len(ta) < len(tb)
+			}
+			if ta != tb {
+				return ta < tb
+			}
+			a, b = ra, rb
+			continue
+		}
+		ca, cb := unicode.ToLower(rune(a[0])), unicode.ToLower(rune(b[0]))
+		if ca != cb {
+			return ca < cb
+		}
+		a, b = a[1:], b[1:]
+	}
+	return len(a) < len(b)
+}
+
+func splitDigits(s string) (string, string) {
+	i := 0
+	for i < len(s) && unicode.IsDigit(rune(s[i])) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// groupBy splits cs by key, the chunks with an empty key being alone
+// in their group. The groups are in the order of their first chunk.
+func groupBy(cs []chunk, key func(*chunk) string) [][]chunk {
+	var (
+		groups [][]chunk
+		index  = make(map[string]int)
+	)
+	for _, c := range cs {
+		k := key(&c)
+		if k == "" {
+			groups = append(groups, []chunk{c})
+			continue
+		}
+		i, ok := index[k]
+		if !ok {
+			i = len(groups)
+			index[k] = i
+			groups = append(groups, nil)
+		}
+		groups[i] = append(groups[i], c)
+	}
+	return groups
+}
+
+// resume sorts the episodes of a series and rotates them so that the
+// series starts after its last aired episode.
+func resume(eps []chunk, st *State) []chunk {
+	sortEpisodes(eps)
+	last := st.lastAired(eps[0].series)
+	for k := range eps {
+		if eps[k].filename == last {
+			return append(append([]chunk{}, eps[k+1:]...), eps[:k+1]...)
+		}
+	}
+	return eps
+}
+
+// interleave merges groups, keeping the order of each group. The next
+// chunk is taken from a group with a probability proportional to its
+// weight.
+func interleave(groups [][]chunk, weight func([]chunk) float64, r *rand.Rand) []chunk {
+	var order []chunk
+	for {
+		total := 0.0
+		for _, g := range groups {
+			if len(g) > 0 {
+				total += weight(g)
+			}
+		}
+		if total == 0 {
+			return order
+		}
+		n := r.Float64() * total
+		for i, g := range groups {
+			if len(g) == 0 {
+				continue
+			}
+			if n -= weight(g); n < 0 || i == len(groups)-1 {
+				order = append(order, g[0])
+				groups[i] = g[1:]
+				break
+			}
+		}
+	}
+}
+
+// seriesOrder orders cs so that the episodes of each series follow
+// each other, starting after the last aired one, while the series (and
+// the episodes that belong to none) are randomly interleaved.
+func seriesOrder(cs []chunk, series func(*chunk) string, st *State, r *rand.Rand) []chunk {
+	groups := groupBy(cs, series)
+	for i, g := range groups {
+		if series(&g[0]) != "" {
+			groups[i] = resume(g, st)
+		}
+	}
+	// picking a group with a probability proportional to the number
+	// of its remaining episodes spreads the series over the order
+	return interleave(groups, func(g []chunk) float64 {
+		return float64(len(g))
+	}, r)
+}
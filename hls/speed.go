@@ -0,0 +1,92 @@
+package hls
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// profile returns what the transcoding speed of c depends on: the codec
+// and the resolution of its video and whether it is copied, encoded or
+// encoded with burnt in subtitles.
+func (c *chunk) profile() string {
+	mode := "encode"
+	if c.subtitle >= 0 {
+		mode = "subtitles"
+	} else if c.info.CopyVideo() {
+		mode = "copy"
+	}
+	v := c.info.Video
+	return fmt.Sprintf("%v %vx%v %v", v.Codec, v.Width, v.Height, mode)
+}
+
+// speed returns the transcoding speed of profile, in seconds of media
+// per second, as measured by the previous transcodings.
+func (st *State) speed(profile string) float64 {
+	if st != nil {
+		st.mu.Lock()
+		s, ok := st.Speeds[profile]
+		st.mu.Unlock()
+		if ok {
+			return s
+		}
+	}
+	// a guess, until it is measured
+	if strings.HasSuffix(profile, " copy") {
+		return 20
+	}
+	return 1
+}
+
+// measured records that d of media of the given profile were transcoded
+// in `took`. The speeds follow the latest measures.
+func (st *State) measured(profile string, d, took time.Duration) {
+	if st == nil || d <= 0 || took <= 0 {
+		return
+	}
+	s := d.Seconds() / took.Seconds()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.Speeds == nil {
+		st.Speeds = make(map[string]float64)
+	}
+	if old, ok := st.Speeds[profile]; ok {
+		s = 0.7*old + 0.3*s
+	}
+	st.Speeds[profile] = s
+	log.Printf("hls: %v transcoded at %.1fx", profile, s)
+}
+
+// Estimate returns, from the speed of the previous transcodings, how
+// long p takes to be ready to air and to be entirely transcoded. What
+// is in the cache takes no time.
+func (p *Program) Estimate() (ready, total time.Duration) {
+	alen, need := p.audioStreams(), p.need()
+	// when each of the p.jobs transcodings is free
+	free := make([]time.Duration, p.jobs)
+	if len(free) == 0 {
+		free = make([]time.Duration, 1)
+	}
+	prepared := time.Duration(0)
+	for i := range p.cs {
+		c := &p.cs[i]
+		k := 0
+		for j := range free {
+			if free[j] < free[k] {
+				k = j
+			}
+		}
+		if !p.tank.cache.has(c.job("", alen)) {
+			free[k] += time.Duration(float64(c.length()) / p.tank.state.speed(c.profile()))
+		}
+		if free[k] > total {
+			total = free[k]
+		}
+		if prepared < need {
+			prepared += c.length()
+			ready = total
+		}
+	}
+	return ready, total
+}
@@ -0,0 +1,105 @@
+package hls
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State is what the station remembers across programs and restarts.
+type State struct {
+	mu      sync.Mutex
+	path    string
+	Series  map[string]string  `json:"series"`
+	Speeds  map[string]float64 `json:"speeds,omitempty"` // see speed
+	history *History
+}
+
+// LoadState reads the state stored at path, a missing or corrupted
+// state is replaced by an empty one.
+func LoadState(path string) *State {
+	st := &State{path: path}
+	if str, err := os.ReadFile(path); err == nil {
+		if err = json.Unmarshal(str, st); err != nil {
+			st = &State{path: path}
+		}
+	}
+	if st.Series == nil {
+		st.Series = make(map[string]string)
+	}
+	return st
+}
+
+// lastAired returns the last aired episode of series.
+func (st *State) lastAired(series string) string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.Series[series]
+}
+
+// lastAiredAt returns when filename was last aired.
+func (st *State) lastAiredAt(filename string) time.Time {
+	st.mu.Lock()
+	h := st.history
+	st.mu.Unlock()
+	return h.lastAired(filename)
+}
+
+func (st *State) aired(c *chunk) {
+	if c.series == "" {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Series[c.series] = c.filename
+}
+
+// clone returns a copy of st that is never saved.
+func (st *State) clone() *State {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	n := &State{
+		Series:  make(map[string]string, len(st.Series)),
+		history: st.history,
+	}
+	for k, v := range st.Series {
+		n.Series[k] = v
+	}
+	n.Speeds = make(map[string]float64, len(st.Speeds))
+	for k, v := range st.Speeds {
+		n.Speeds[k] = v
+	}
+	return n
+}
+
+func (st *State) Save() error {
+	if st == nil || st.path == "" {
+		return nil
+	}
+	st.mu.Lock()
+	str, err := json.MarshalIndent(st, "", "  ")
+	st.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFile(st.path, str)
+}
+
+// writeFile atomically replaces the content of filename.
+func writeFile(filename string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), "."+filepath.Base(filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,121 @@
+package hls
+
+import (
+	"log"
+	"math/rand"
+	"path/filepath"
+	"sort"
+)
+
+// strategy decides in which order the chunks of the tank are aired.
+// Order must not modify cs.
+type strategy interface {
+	Order(cs []chunk, st *State, r *rand.Rand) []chunk
+}
+
+// strategies are the strategies that can be selected with `order`,
+// see config.Orders.
+var strategies = map[string]strategy{
+	"shuffle":      shuffleStrategy{},
+	"series":       seriesStrategy{},
+	"sequential":   sequentialStrategy{},
+	"weighted":     weightedStrategy{},
+	"least-recent": leastRecentStrategy{},
+}
+
+func strategyOf(name string) strategy {
+	s, ok := strategies[name]
+	if !ok {
+		log.Printf("hls: unknown order '%v', shuffling", name)
+		return shuffleStrategy{}
+	}
+	return s
+}
+
+// shuffleStrategy shuffles the library, series excepted: their episodes
+// are aired in order.
+type shuffleStrategy struct{}
+
+func (shuffleStrategy) Order(cs []chunk, st *State, r *rand.Rand) []chunk {
+	return seriesOrder(cs, func(c *chunk) string {
+		if c.sequential {
+			return c.series
+		}
+		return ""
+	}, st, r)
+}
+
+// seriesStrategy is shuffleStrategy where every directory is a series,
+// unless its `.conf` says `order shuffle`.
+type seriesStrategy struct{}
+
+func (seriesStrategy) Order(cs []chunk, st *State, r *rand.Rand) []chunk {
+	return seriesOrder(cs, func(c *chunk) string {
+		return c.series
+	}, st, r)
+}
+
+// sequentialStrategy airs the whole library in the order of the paths,
+// starting after the last aired file.
+type sequentialStrategy struct{}
+
+func (sequentialStrategy) Order(cs []chunk, st *State, r *rand.Rand) []chunk {
+	if len(cs) == 0 {
+		return nil
+	}
+	order := append([]chunk{}, cs...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return naturalLess(order[i].filename, order[j].filename)
+	})
+	last, k := st.lastAiredAt(order[0].filename), 0
+	for i := range order {
+		if t := st.lastAiredAt(order[i].filename); t.After(last) {
+			last, k = t, i
+		}
+	}
+	if last.IsZero() {
+		return order
+	}
+	return append(order[k+1:], order[:k+1]...)
+}
+
+// weightedStrategy picks the directories at random, with a probability
+// proportional to their `weight`. The files of a directory are shuffled,
+// unless they form a series.
+type weightedStrategy struct{}
+
+func (weightedStrategy) Order(cs []chunk, st *State, r *rand.Rand) []chunk {
+	groups := groupBy(cs, func(c *chunk) string {
+		if c.sequential {
+			return c.series
+		}
+		return filepath.Dir(c.filename)
+	})
+	for i, g := range groups {
+		if g[0].sequential {
+			groups[i] = resume(g, st)
+		} else {
+			r.Shuffle(len(g), func(a, b int) {
+				g[a], g[b] = g[b], g[a]
+			})
+		}
+	}
+	return interleave(groups, func(g []chunk) float64 {
+		return g[0].weight
+	}, r)
+}
+
+// leastRecentStrategy airs first what has never been aired, then what
+// has been aired the longest time ago.
+type leastRecentStrategy struct{}
+
+func (leastRecentStrategy) Order(cs []chunk, st *State, r *rand.Rand) []chunk {
+	order := append([]chunk{}, cs...)
+	r.Shuffle(len(order), func(i, j int) {
+		order[i], order[j] = order[j], order[i]
+	})
+	sort.SliceStable(order, func(i, j int) bool {
+		return st.lastAiredAt(order[i].filename).Before(st.lastAiredAt(order[j].filename))
+	})
+	return order
+}
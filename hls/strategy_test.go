@@ -0,0 +1,25 @@
+package hls
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWeight(t *testing.T) {
+	dir := t.TempDir()
+	for _, w := range []string{"0", "-1", "x"} {
+		f := filepath.Join(dir, ".conf")
+		if err := os.WriteFile(f, []byte("weight "+w+"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		dc, errs := readConfig(f, rootDirConfig())
+		if len(errs) != 1 || !strings.Contains(errs[0].Error(), "invalid weight") {
+			t.Errorf("weight %v: errors %v", w, errs)
+		}
+		if dc.weight != 1 {
+			t.Errorf("weight %v: weight %v, want 1", w, dc.weight)
+		}
+	}
+}
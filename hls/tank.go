@@ -1,44 +1,163 @@
 package hls
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vonaka/smc_station/config"
 )
 
+// Tank is the media library. A reload replaces its content while the
+// programs made from the previous one may still be transcoding, they
+// keep a snapshot of it.
 type Tank struct {
-	cs []chunk
-	ds []time.Duration
+	mu      sync.RWMutex
+	cs      []chunk
+	clips   map[string][]chunk
+	index   *Index
+	state   *State
+	history *History
+	cache   *Cache
+	// noRepeat is the no-repeat policy the history is compacted for,
+	// retention how long the airings are kept anyway.
+	noRepeat  time.Duration
+	retention time.Duration
+	probed    int32
+	toProbe   int32
+	cached    bool
+	// dry tanks are only read to report or plan, the history is left
+	// as it is
+	dry    bool
+	report []FileReport
 }
 
 type chunk struct {
 	filename    string
+	series      string
+	sequential  bool
+	kind        string
+	trim        time.Duration
+	weight      float64
 	duration    time.Duration
-	vcodec      string
+	info        *MediaInfo
 	videostream []int
 	audiostream []int
+	subtitle    int
 }
 
-func NewDataTank(c *config.Config) (*Tank, error) {
+func NewDataTank(ctx context.Context, c *config.Config) (*Tank, error) {
 	t := &Tank{}
-	err := t.fillChunks(c)
+	t.load(c)
+	err := t.fillChunks(ctx, c)
 	return t, err
 }
 
-func (t *Tank) Update(c *config.Config) error {
-	return t.fillChunks(c)
+func (t *Tank) Update(ctx context.Context, c *config.Config) error {
+	t.load(c)
+	return t.fillChunks(ctx, c)
+}
+
+// load reads the index, the state and the history of t, unless they
+// are already loaded from the files given by c.
+func (t *Tank) load(c *config.Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.index == nil || t.index.path != c.IndexFile() {
+		t.index = LoadIndex(c.IndexFile())
+	}
+	if t.history == nil || t.history.path != c.HistoryFile() {
+		t.history = LoadHistory(c.HistoryFile())
+	}
+	if t.state == nil || t.state.path != c.StateFile() {
+		t.state = LoadState(c.StateFile())
+	}
+	t.state.mu.Lock()
+	t.state.history = t.history
+	t.state.mu.Unlock()
+	t.noRepeat, t.retention = c.NoRepeat(), c.HistoryRetention()
+	if c.CacheSize() == 0 {
+		t.cache = nil
+	} else if t.cache == nil || t.cache.dir != c.CacheDir() {
+		t.cache = OpenCache(c.CacheDir(), c.CacheSize())
+	} else {
+		t.cache.mu.Lock()
+		t.cache.max = c.CacheSize()
+		t.cache.mu.Unlock()
+	}
+}
+
+// snapshot returns what programs are made from: the chunks, the clips,
+// the state, the history and the cache of t as they are now.
+func (t *Tank) snapshot() *Tank {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return &Tank{
+		cs:        t.cs,
+		clips:     t.clips,
+		state:     t.state,
+		history:   t.history,
+		cache:     t.cache,
+		noRepeat:  t.noRepeat,
+		retention: t.retention,
+	}
+}
+
+// compact compacts the history of t (see History.compact) before the
+// no-repeat window, or before the current cycle of the library cs with
+// exhaust: no-repeat only needs to know when the files aired before
+// were last aired. The airings within the retention of t are kept, and
+// with no-repeat off nothing is compacted without a retention.
+func (t *Tank) compact(cs []chunk, now time.Time) {
+	if t.retention == config.Forever {
+		return
+	}
+	before := now
+	switch t.noRepeat {
+	case config.Repeat:
+		if t.retention == 0 {
+			return
+		}
+	case config.Exhaust:
+		lib := make(map[string]bool, len(cs))
+		for _, c := range cs {
+			lib[c.filename] = true
+		}
+		if _, start := t.history.cycle(lib); !start.IsZero() {
+			before = start
+		}
+	default:
+		before = now.Add(-t.noRepeat)
+	}
+	if t.retention > 0 && now.Add(-t.retention).Before(before) {
+		before = now.Add(-t.retention)
+	}
+	if n, err := t.history.compact(before); err != nil {
+		log.Println("history:", err)
+	} else if n > 0 {
+		log.Printf("history: %v airings removed", n)
+	}
 }
 
 func (t *Tank) String() (s string) {
-	if t == nil || t.cs == nil {
+	if t == nil {
+		return "<empty>"
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.cs == nil {
 		return "<empty>"
 	}
 	for _, c := range t.cs {
@@ -48,149 +167,635 @@ func (t *Tank) String() (s string) {
 	return
 }
 
-func (t *Tank) Shuffle() {
-	rand.Shuffle(len(t.cs), func(i, j int) {
-		t.cs[i], t.cs[j] = t.cs[j], t.cs[i]
-	})
-	d := time.Duration(0)
-	for _, c := range t.cs {
-		d += c.duration
-		t.ds = append(t.ds, d)
+var defaultTank *Tank
+
+func InitializeDataTank(ctx context.Context, c *config.Config) (err error) {
+	if defaultTank == nil {
+		rand.Seed(time.Now().UnixNano())
+		defaultTank, err = NewDataTank(ctx, c)
+		return err
 	}
+	return nil
 }
 
-var defaultTank *Tank
+// InitializeCachedDataTank initializes the tank from the index only,
+// nothing is probed.
+func InitializeCachedDataTank(c *config.Config) (err error) {
+	if defaultTank == nil {
+		rand.Seed(time.Now().UnixNano())
+		defaultTank = &Tank{cached: true}
+		defaultTank.load(c)
+		return defaultTank.fillChunks(context.Background(), c)
+	}
+	return nil
+}
 
-func InitializeDataTank(c *config.Config) (err error) {
+// InitializeDryTank initializes the tank to report on the library or to
+// plan programs only: nothing but the index is written. Unless cached,
+// the files that are not in the index are probed.
+func InitializeDryTank(ctx context.Context, c *config.Config, cached bool) error {
 	if defaultTank == nil {
 		rand.Seed(time.Now().UnixNano())
-		defaultTank, err = NewDataTank(c)
-		return err
+		defaultTank = &Tank{cached: cached, dry: true}
+		defaultTank.load(c)
+		return defaultTank.fillChunks(ctx, c)
 	}
 	return nil
 }
 
-func UpdateTank(c *config.Config) error {
+func UpdateTank(ctx context.Context, c *config.Config) error {
 	if defaultTank != nil {
-		return defaultTank.Update(c)
+		return defaultTank.Update(ctx, c)
 	}
 	return nil
 }
 
-func ShuffleTank() {
+func TankProgress() ScanProgress {
+	if defaultTank != nil {
+		return defaultTank.Progress()
+	}
+	return ScanProgress{}
+}
+
+// Outdated reports whether a file of p has left the library since p was
+// made, or has been changed (its content or its .conf settings).
+func Outdated(p *Program) bool {
+	if defaultTank == nil {
+		return false
+	}
+	lib := defaultTank.snapshot()
+	cs := make(map[string]*chunk, len(lib.cs))
+	for i := range lib.cs {
+		cs[lib.cs[i].filename] = &lib.cs[i]
+	}
+	for _, clips := range lib.clips {
+		for i := range clips {
+			cs[clips[i].filename] = &clips[i]
+		}
+	}
+	for i := range p.cs {
+		c, ok := cs[p.cs[i].filename]
+		if !ok || !c.same(&p.cs[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// same reports whether c and o are made from the same file with the same
+// settings, whatever their trimming.
+func (c *chunk) same(o *chunk) bool {
+	return c.filename == o.filename && c.info == o.info &&
+		c.subtitle == o.subtitle &&
+		reflect.DeepEqual(c.audiostream, o.audiostream) &&
+		reflect.DeepEqual(c.videostream, o.videostream)
+}
+
+// Airs starts to air p at `at`. Its episodes are recorded as aired by
+// Record once they are over, so that a crash does not mark as aired
+// those that were not.
+func Airs(p *Program, at time.Time) {
+	p.mu.Lock()
+	p.start = at
+	p.mu.Unlock()
+}
+
+// Record records in the history and the state the episodes of p that
+// are over at `now`, and returns when the next one is (zero once they
+// all are).
+func Record(p *Program, now time.Time) (time.Time, error) {
+	is, as, ends := p.pending()
+	n := 0
+	for n < len(is) && !ends[n].After(now) {
+		n++
+	}
+	var next time.Time
+	if n < len(is) {
+		next = ends[n]
+	}
+	if n == 0 {
+		return next, nil
+	}
+	return next, p.record(is[:n], as[:n])
+}
+
+// Aired records that p is entirely aired from `at`.
+func Aired(p *Program, at time.Time) error {
+	Airs(p, at)
+	is, as, _ := p.pending()
+	return p.record(is, as)
+}
+
+// record records the airings as of the chunks is of p.
+func (p *Program) record(is []int, as []Airing) error {
+	p.mu.Lock()
+	for _, i := range is {
+		p.recorded[i] = true
+	}
+	p.mu.Unlock()
+	return p.tank.aired(p.cs, is, as)
+}
+
+// pending returns the chunks of the episodes of p that are not recorded
+// yet (but those cut to fit the slot), their airings and when they end.
+func (p *Program) pending() (is []int, as []Airing, ends []time.Time) {
+	es := p.Timeline()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.recorded == nil {
+		p.recorded = make([]bool, len(p.cs))
+	}
+	i := 0
+	for _, e := range es {
+		if e.Kind == "slate" {
+			continue
+		}
+		// an episode cut to fit its slot is not aired entirely:
+		// it airs again and its series does not move on
+		if p.cs[i].kind == "" && p.cs[i].trim == 0 && !p.recorded[i] {
+			is = append(is, i)
+			as = append(as, Airing{
+				File:   e.File,
+				Series: e.Series,
+				Slot:   p.window.Slot,
+				Time:   e.Start,
+			})
+			ends = append(ends, e.Start.Add(e.Duration))
+		}
+		i++
+	}
+	return is, as, ends
+}
+
+// aired records in the state and the history of t the airings as of
+// the chunks is of cs.
+func (t *Tank) aired(cs []chunk, is []int, as []Airing) error {
+	for _, i := range is {
+		t.state.aired(&cs[i])
+	}
+	if err := t.history.Record(as); err != nil {
+		return err
+	}
+	if t.history.grown() {
+		t.compact(t.cs, time.Now())
+	}
+	return t.state.Save()
+}
+
+// Report returns what the last scan of the library found out.
+func Report() []FileReport {
 	if defaultTank != nil {
-		defaultTank.Shuffle()
+		defaultTank.mu.RLock()
+		defer defaultTank.mu.RUnlock()
+		return defaultTank.report
 	}
+	return nil
 }
 
 func ReadTank() string {
 	return defaultTank.String()
 }
 
-func (t *Tank) fillChunks(c *config.Config) error {
-	var readDir func(string, []int, []int, map[string]struct{}) error
-	readDir = func(dir string, videos, audios []int, skip map[string]struct{}) error {
+// ScanProgress tells how many of the files that are not in the index
+// have been probed during the current (or last) scan.
+type ScanProgress struct {
+	Probed int
+	Total  int
+}
+
+type candidate struct {
+	filename string
+	series   string
+	kind     string
+	info     os.FileInfo
+	dir      *dirConfig
+}
+
+// dirConfig holds the settings of the per-directory .conf files.
+type dirConfig struct {
+	video     []int
+	audio     *trackRule
+	subtitles *trackRule
+	skip      []*config.Pattern
+	series    string
+	shuffle   bool
+	weight    float64
+}
+
+// Progress returns the progress of the scan of t.
+func (t *Tank) Progress() ScanProgress {
+	return ScanProgress{
+		Probed: int(atomic.LoadInt32(&t.probed)),
+		Total:  int(atomic.LoadInt32(&t.toProbe)),
+	}
+}
+
+// FileReport is what the last scan found out about a file. Skipped
+// tells why the file is left out, if it is.
+type FileReport struct {
+	File     string     `json:"file"`
+	Kind     string     `json:"kind,omitempty"`
+	Skipped  string     `json:"skipped,omitempty"`
+	Info     *MediaInfo `json:"info,omitempty"`
+	Audio    []int      `json:"audio,omitempty"`
+	Subtitle int        `json:"subtitle"`
+}
+
+// walk lists the media files of the data directory, then those of the
+// filler and interstitial directories, the files left out and the errors
+// of the .conf files read on the way.
+func walk(c *config.Config) ([]candidate, []FileReport, config.ErrorList, error) {
+	var (
+		cs      []candidate
+		skipped []FileReport
+		errs    config.ErrorList
+		kind    string
+		readDir func(string, *dirConfig) error
+		kinds   []string
+		dirs    = make(map[string]string)
+	)
+	if d := c.FillerDir(); d != "" {
+		kinds = append(kinds, "filler")
+		dirs["filler"] = d
+	}
+	for _, k := range config.Interstitials {
+		if cl, ok := c.Interstitial(k); ok {
+			kinds = append(kinds, k)
+			dirs[k] = cl.Dir
+		}
+	}
+	isClipDir := func(dir string) bool {
+		for _, d := range dirs {
+			if d == dir {
+				return true
+			}
+		}
+		return false
+	}
+	readDir = func(dir string, parent *dirConfig) error {
 		vs, err := ioutil.ReadDir(dir)
 		if err != nil {
 			return err
 		}
 
+		dc := parent
 		for _, v := range vs {
 			if e := filepath.Ext(v.Name()); e == ".conf" || e == ".config" {
-				vsp, asp, skipp := readConfig(filepath.Join(dir, v.Name()))
-				if vsp != nil {
-					videos = vsp
-				}
-				if asp != nil {
-					audios = asp
-				}
-				if skipp != nil && len(skipp) > 0 {
-					skip = skipp
-				}
+				var es config.ErrorList
+				dc, es = readConfig(filepath.Join(dir, v.Name()), dc)
+				errs = append(errs, es...)
 			}
 		}
 
 		for _, v := range vs {
-			_, toSkip := skip[v.Name()]
-			toSkip = toSkip || c.Ignore(filepath.Join(dir, v.Name()))
+			why := ""
+			if config.MatchAny(dc.skip, filepath.Join(dir, v.Name())) {
+				why = "skipped by .conf"
+			} else if c.Ignore(filepath.Join(dir, v.Name())) {
+				why = "ignored by config"
+			}
+			if why != "" {
+				skipped = append(skipped, FileReport{
+					File:     filepath.Join(dir, v.Name()),
+					Kind:     kind,
+					Skipped:  why,
+					Subtitle: -1,
+				})
+				continue
+			}
 			if v.IsDir() {
-				if toSkip {
+				if isClipDir(filepath.Join(dir, v.Name())) {
 					continue
 				}
-				err = readDir(filepath.Join(dir, v.Name()), videos, audios, skip)
+				err = readDir(filepath.Join(dir, v.Name()), dc)
 				if err != nil {
 					return err
 				}
 			} else {
-				if !toSkip && check(v.Name()) {
-					filename := filepath.Join(dir, v.Name())
-					duration, err := videoDuration(filename)
-					codec := videoCodec(filename)
-					if err == nil {
-						t.cs = append(t.cs, chunk{
-							filename:    filename,
-							duration:    duration,
-							vcodec:      codec,
-							videostream: copySlice(videos),
-							audiostream: copySlice(audios),
-						})
-					} else {
+				if e := filepath.Ext(v.Name()); e == ".conf" || e == ".config" {
+					continue
+				}
+				filename := filepath.Join(dir, v.Name())
+				if !check(v.Name()) {
+					skipped = append(skipped, FileReport{
+						File:     filename,
+						Kind:     kind,
+						Skipped:  "not a media file",
+						Subtitle: -1,
+					})
+				} else {
+					info, err := os.Stat(filename)
+					if err != nil {
 						log.Println("skipping", filename)
+						skipped = append(skipped, FileReport{
+							File:     filename,
+							Kind:     kind,
+							Skipped:  err.Error(),
+							Subtitle: -1,
+						})
+						continue
+					}
+					series := dc.series
+					if series == "" && !dc.shuffle {
+						series = dir
 					}
+					cs = append(cs, candidate{
+						filename: filename,
+						series:   series,
+						kind:     kind,
+						info:     info,
+						dir:      dc,
+					})
 				}
 			}
 		}
 		return nil
 	}
-	return readDir(c.DataDir(), []int{0}, []int{0}, make(map[string]struct{}))
+	root := rootDirConfig()
+	if err := readDir(c.DataDir(), root); err != nil {
+		return cs, skipped, errs, err
+	}
+	for _, kind = range kinds {
+		if err := readDir(dirs[kind], root); err != nil {
+			log.Printf("library: no %v: %v", kind, err)
+		}
+	}
+	return cs, skipped, errs, nil
 }
 
-func readConfig(filename string) ([]int, []int, map[string]struct{}) {
-	str, err := os.ReadFile(filename)
+// fillChunks walks the data directory and probes, with c.ProbeWorkers()
+// workers, the files that are not in the index (unless t is cached, they
+// are then left out). The order of the chunks only depends on the
+// content of the data directory.
+func (t *Tank) fillChunks(ctx context.Context, c *config.Config) error {
+	cands, skipped, errs, err := walk(c)
+	for _, e := range errs {
+		log.Println(e)
+	}
 	if err != nil {
-		return nil, nil, nil
+		return err
 	}
-	var (
-		vs []int
-		as []int
-	)
-	stringsToInts := func(s []string) []int {
-		i := make([]int, len(s))
-		c := 0
-		for j := range s {
-			k, err := strconv.Atoi(s[j])
-			if err == nil {
-				i[j] = k
-				c++
+
+	es := make([]*indexEntry, len(cands))
+	todo := make(chan int)
+	total := 0
+	for i, cd := range cands {
+		if e, ok := t.index.lookup(cd.filename, cd.info); ok {
+			es[i] = e
+		} else if !t.cached {
+			total++
+		}
+	}
+	atomic.StoreInt32(&t.probed, 0)
+	atomic.StoreInt32(&t.toProbe, int32(total))
+
+	var wg sync.WaitGroup
+	for w := 0; w < c.ProbeWorkers(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range todo {
+				e, keep := probe(ctx, cands[i].filename)
+				if ctx.Err() != nil {
+					return
+				}
+				if keep {
+					t.index.store(cands[i].filename, cands[i].info, e)
+				}
+				es[i] = e
+				n := int(atomic.AddInt32(&t.probed, 1))
+				if n%10 == 0 || n == total {
+					log.Printf("library: probed %v/%v files", n, total)
+				}
 			}
+		}()
+	}
+feed:
+	for i := range cands {
+		if es[i] != nil || t.cached {
+			continue
+		}
+		select {
+		case todo <- i:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(todo)
+	wg.Wait()
+
+	probed := int(atomic.LoadInt32(&t.probed))
+	if err := ctx.Err(); err != nil {
+		// keep what has been probed so far
+		if probed > 0 {
+			t.index.Save()
 		}
-		if c == 0 {
-			return nil
+		return err
+	}
+
+	var cs []chunk
+	clips := make(map[string][]chunk)
+	report := skipped
+	for i, cd := range cands {
+		r := FileReport{File: cd.filename, Kind: cd.kind, Subtitle: -1}
+		if es[i] == nil {
+			log.Println("not in the index, skipping", cd.filename)
+			r.Skipped = "not in the index"
+			report = append(report, r)
+			continue
 		}
-		return i[0:c]
+		r.Info = es[i].Info
+		if es[i].Err != "" {
+			log.Println("skipping", cd.filename)
+			r.Skipped = "ffprobe: " + es[i].Err
+			report = append(report, r)
+			continue
+		}
+		ch := chunk{
+			filename:    cd.filename,
+			series:      cd.series,
+			sequential:  cd.dir.series != "",
+			weight:      cd.dir.weight,
+			duration:    es[i].Info.Duration,
+			info:        es[i].Info,
+			videostream: copySlice(cd.dir.video),
+			audiostream: cd.dir.audio.audio(es[i].Info),
+			subtitle:    cd.dir.subtitles.subtitle(es[i].Info),
+		}
+		r.Audio, r.Subtitle = ch.audiostream, ch.subtitle
+		if cd.kind == "" {
+			cs = append(cs, ch)
+		} else {
+			ch.kind, ch.series = cd.kind, ""
+			clips[cd.kind] = append(clips[cd.kind], ch)
+		}
+		report = append(report, r)
 	}
-	skip := make(map[string]struct{})
+	sort.SliceStable(report, func(i, j int) bool {
+		return report[i].File < report[j].File
+	})
+	t.mu.Lock()
+	t.cs, t.clips, t.report = cs, clips, report
+	t.mu.Unlock()
+	if !t.dry {
+		t.compact(cs, time.Now())
+	}
+	if t.cached {
+		log.Printf("library: %v files", len(cs))
+		return nil
+	}
+	removed := t.index.prune()
+	log.Printf("library: %v files, %v probed, %v removed from the index",
+		len(cs), probed, removed)
+	for _, k := range append([]string{"filler"}, config.Interstitials...) {
+		if n := len(clips[k]); n > 0 {
+			log.Printf("library: %v %v clips", n, k)
+		}
+	}
+	if probed > 0 || removed > 0 {
+		if err := t.index.Save(); err != nil {
+			log.Println("library index:", err)
+		}
+	}
+	return nil
+}
+
+// rootDirConfig returns the settings of the directories without .conf.
+func rootDirConfig() *dirConfig {
+	return &dirConfig{
+		audio:  &trackRule{indexes: []int{0}, fallback: "default"},
+		weight: 1,
+	}
+}
+
+// CheckDirConfigs reads the .conf files of the library, filler and
+// interstitial directories with the rules of the scan (the directories
+// ignored or skipped are left out) and returns the errors they contain.
+func CheckDirConfigs(c *config.Config) config.ErrorList {
+	_, _, errs, _ := walk(c)
+	return errs
+}
+
+// readConfig reads a per-directory .conf file, the settings it does
+// not define are those of parent.
+func readConfig(filename string, parent *dirConfig) (*dirConfig, config.ErrorList) {
+	var errs config.ErrorList
+	errorf := func(n, col int, format string, a ...interface{}) {
+		errs = append(errs, &config.Error{
+			File: filename,
+			Line: n + 1,
+			Col:  col,
+			Err:  fmt.Errorf(format, a...),
+		})
+	}
+	str, err := os.ReadFile(filename)
+	if err != nil {
+		return parent, append(errs, &config.Error{File: filename, Err: err})
+	}
+	dc := *parent
+	var skip []*config.Pattern
 	lines := strings.Split(string(str), "\n")
-	for _, l := range lines {
-		words := strings.Fields(l)
-		if len(words) < 2 {
+	for n, l := range lines {
+		fs, err := config.SplitFields(l)
+		if err != nil {
+			col := 1
+			var e *config.Error
+			if errors.As(err, &e) {
+				col, err = e.Col, e.Err
+			}
+			errorf(n, col, "%v", err)
+			continue
+		}
+		if len(fs) == 1 {
+			errorf(n, fs[0].Start+1, "'%v' lacks argument", fs[0].Value)
+		}
+		if len(fs) < 2 {
 			continue
 		}
-		switch words[0] {
+		// at reports an error about the field f
+		at := func(f config.Field, format string, a ...interface{}) {
+			errorf(n, f.Start+1, format, a...)
+		}
+		switch fs[0].Value {
 		case "video":
-			vs = stringsToInts(words[1:])
-		case "audio":
-			as = stringsToInts(words[1:])
+			if v, err := strconv.Atoi(fs[1].Value); err != nil || v < 0 {
+				at(fs[1], "invalid stream '%v'", fs[1].Value)
+			} else {
+				dc.video = []int{v}
+			}
+			if len(fs) > 2 {
+				at(fs[2], "only one video stream can be used")
+			}
+		case "audio", "subtitles":
+			if fs[0].Value == "subtitles" && fs[1].Value == "off" {
+				dc.subtitles = nil
+				continue
+			}
+			r := &trackRule{fallback: "default"}
+			if fs[0].Value == "subtitles" {
+				r.fallback = "none"
+			}
+			ok := true
+			for _, f := range fs[1:] {
+				if err := r.parse(f.Value); err != nil {
+					at(f, "%v: %v", fs[0].Value, err)
+					ok = false
+				}
+			}
+			if !ok {
+				continue
+			}
+			if err := r.check(); err != nil {
+				at(fs[1], "%v: %v", fs[0].Value, err)
+				continue
+			}
+			if fs[0].Value == "audio" {
+				dc.audio = r
+			} else {
+				dc.subtitles = r
+			}
+		case "order":
+			switch fs[1].Value {
+			case "sequential":
+				dc.series, dc.shuffle = filepath.Dir(filename), false
+			case "shuffle":
+				dc.series, dc.shuffle = "", true
+			default:
+				at(fs[1], "unknown order '%v'", fs[1].Value)
+			}
+		case "weight":
+			w, err := strconv.ParseFloat(fs[1].Value, 64)
+			if err != nil || w <= 0 {
+				// a directory that never airs is skipped
+				at(fs[1], "invalid weight '%v', it must be positive", fs[1].Value)
+				continue
+			}
+			dc.weight = w
 		case "skip":
 			fallthrough
 		case "ignore":
-			// FIXME: what if filenames contain '"'?
-			for _, w := range words[1:] {
-				skip[w] = struct{}{}
+			for _, f := range fs[1:] {
+				// names are matched in any subdirectory,
+				// paths are relative to the .conf file
+				base := ""
+				if strings.Contains(f.Value, "/") {
+					base = filepath.Dir(filename)
+				}
+				p, err := config.NewPattern(f.Value, base)
+				if err != nil {
+					at(f, "%v", err)
+					continue
+				}
+				skip = append(skip, p)
 			}
+		default:
+			at(fs[0], "unknown directive '%v'", fs[0].Value)
 		}
 	}
-	return vs, as, skip
+	if len(skip) > 0 {
+		dc.skip = skip
+	}
+	return &dc, errs
 }
 
 func copySlice(s []int) []int {
@@ -214,30 +819,26 @@ func check(filename string) bool {
 	return isOk
 }
 
-func videoDuration(filename string) (time.Duration, error) {
-	len, err := exec.Command("ffprobe", "-v", "error",
-		"-show_entries", "format=duration",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		filename).Output()
+// probe probes filename and tells whether the result is kept in the
+// index: it is not when ffprobe could not do its job (it is missing,
+// the file cannot be read), the file is then probed again on the next
+// scan.
+func probe(ctx context.Context, filename string) (*indexEntry, bool) {
+	info, err := Probe(ctx, filename)
 	if err != nil {
-		log.Println("videoDuration:", err)
-		return time.Duration(0), err
+		log.Println("probe:", filename, err)
+		var perr *ProbeError
+		return &indexEntry{Err: err.Error()}, errors.As(err, &perr)
 	}
-	lenStr := string(strings.Split(string(len), "\n")[0]) + "s"
-	return time.ParseDuration(lenStr)
+	return &indexEntry{Info: info}, true
 }
 
-func videoCodec(filename string) string {
-	ls, err := exec.Command("ffprobe", "-v", "error",
-		"-select_streams", "v:0",
-		"-show_entries", "stream=codec_name",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		filename).Output()
-	if err != nil {
-		log.Println("videoCodec:", err, filename, "vcodec = none")
-		return "none"
+// length returns how long c airs.
+func (c chunk) length() time.Duration {
+	if c.trim > 0 {
+		return c.trim
 	}
-	return strings.Split(string(ls), "\n")[0]
+	return c.duration
 }
 
 func (c chunk) String() string {
@@ -0,0 +1,460 @@
+package hls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vonaka/smc_station/config"
+)
+
+// testConfig writes in dir the config file conf, after the directories
+// and files of the station, and reads it.
+func testConfig(t *testing.T, dir, conf string) *config.Config {
+	t.Helper()
+	path := writeConfig(t, dir, conf)
+	c, err := config.Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func writeConfig(t *testing.T, dir, conf string) string {
+	t.Helper()
+	conf = fmt.Sprintf("data %v\nindex %v\nstate %v\nhistory %v\ncache-size 0\n",
+		config.Quote(filepath.Join(dir, "data")),
+		config.Quote(filepath.Join(dir, "smc.index")),
+		config.Quote(filepath.Join(dir, "smc.state")),
+		config.Quote(filepath.Join(dir, "smc.history"))) + conf
+	path := filepath.Join(dir, "smc.conf")
+	if err := os.WriteFile(path, []byte(conf), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testLibrary writes in the data directory of c the files of infos,
+// which are put in the index, and returns the tank made of them.
+func testLibrary(t *testing.T, c *config.Config, infos map[string]*MediaInfo) *Tank {
+	t.Helper()
+	es := make(map[string]*indexEntry)
+	for name, info := range infos {
+		f := filepath.Join(c.DataDir(), name)
+		if err := os.MkdirAll(filepath.Dir(f), 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		es[f] = &indexEntry{Size: fi.Size(), ModTime: fi.ModTime(), Info: info}
+	}
+	str, err := json.Marshal(indexFile{indexVersion, es})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(c.IndexFile(), str, 0666); err != nil {
+		t.Fatal(err)
+	}
+	tk := &Tank{cached: true}
+	tk.load(c)
+	if err = tk.fillChunks(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	return tk
+}
+
+func episode(d time.Duration) *MediaInfo {
+	return &MediaInfo{
+		Duration: d,
+		Video:    VideoStream{Codec: "h264", Width: 640, Height: 480},
+		Audio:    []AudioStream{{Codec: "aac", Channels: 2, Default: true}},
+	}
+}
+
+// slowTranscoder is a FakeTranscoder that takes some time.
+type slowTranscoder struct {
+	FakeTranscoder
+	delay time.Duration
+}
+
+func (s *slowTranscoder) Transcode(ctx context.Context, j *Job) error {
+	time.Sleep(s.delay)
+	return s.FakeTranscoder.Transcode(ctx, j)
+}
+
+func setTranscoder(t *testing.T, tr Transcoder) {
+	old := defaultTranscoder
+	SetTranscoder(tr)
+	t.Cleanup(func() { SetTranscoder(old) })
+}
+
+func TestReloadDuringPreparation(t *testing.T) {
+	dir := t.TempDir()
+	slot := "slot test daily 8:00AM 1h\n"
+	c := testConfig(t, dir, slot)
+	tk := testLibrary(t, c, map[string]*MediaInfo{
+		"a/1.mkv": episode(10 * time.Minute),
+		"a/2.mkv": episode(10 * time.Minute),
+		"b/1.mkv": episode(20 * time.Minute),
+		"b/2.mkv": episode(15 * time.Minute),
+	})
+	setTranscoder(t, &slowTranscoder{delay: 20 * time.Millisecond})
+
+	w := c.NextWindow(time.Now())
+	p, err := tk.program(c, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- p.Write(context.Background(), filepath.Join(dir, "program.m3u8"))
+	}()
+
+	// the state and the history move while p is transcoded
+	for i := 0; i < 5; i++ {
+		conf := slot + fmt.Sprintf("state %v\nhistory %v\n",
+			config.Quote(filepath.Join(dir, fmt.Sprintf("%v.state", i))),
+			config.Quote(filepath.Join(dir, fmt.Sprintf("%v.history", i))))
+		writeConfig(t, dir, conf)
+		if err := c.Update(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tk.Update(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tk.program(c, w); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err := Aired(p, w.Start); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(c.HistoryFile()); !os.IsNotExist(err) {
+		t.Errorf("%v aired into the history of the new library", p.Playlist())
+	}
+}
+
+// fakeProbe is an ffprobe that fails on the files named broken and
+// says nothing about those named empty.
+const fakeProbe = `#!/bin/sh
+for f; do :; done
+case "$f" in
+*broken*) echo "Invalid data found when processing input" >&2; exit 1;;
+*empty*) echo '{}';;
+*) echo '{"streams": [{"codec_type": "video", "codec_name": "h264"},
+	{"codec_type": "audio", "codec_name": "aac"}],
+	"format": {"duration": "60"}}';;
+esac
+`
+
+func TestProbeFailures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("ffprobe is a shell script")
+	}
+	dir := t.TempDir()
+	c := testConfig(t, dir, "slot test daily 8:00AM 1h\nprobe-workers 1\n")
+	if err := os.MkdirAll(c.DataDir(), 0775); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"good.mkv", "broken.mkv", "empty.mkv"} {
+		if err := os.WriteFile(filepath.Join(c.DataDir(), f), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bin := filepath.Join(dir, "bin")
+	if err := os.Mkdir(bin, 0775); err != nil {
+		t.Fatal(err)
+	}
+
+	// without ffprobe, nothing is kept
+	t.Setenv("PATH", bin)
+	tk := &Tank{}
+	tk.load(c)
+	if err := tk.fillChunks(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if n := tk.index.Len(); n != 0 {
+		t.Errorf("%v files in the index without ffprobe, want 0", n)
+	}
+	if n := len(tk.report); n != 3 || tk.report[0].Skipped == "" {
+		t.Errorf("report %+v, want 3 skipped files", tk.report)
+	}
+
+	if err := os.WriteFile(filepath.Join(bin, "ffprobe"), []byte(fakeProbe), 0775); err != nil {
+		t.Fatal(err)
+	}
+	if err := tk.Update(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if len(tk.cs) != 1 {
+		t.Errorf("library %v, want good.mkv", tk.cs)
+	}
+	ix := LoadIndex(c.IndexFile())
+	for f, want := range map[string]string{
+		"good.mkv":   "",
+		"broken.mkv": "Invalid data found when processing input",
+		"empty.mkv":  ErrNoStream.Error(),
+	} {
+		e, ok := ix.entries[filepath.Join(c.DataDir(), f)]
+		if !ok {
+			t.Errorf("%v is not in the index", f)
+		} else if e.Err != want {
+			t.Errorf("%v: error %q in the index, want %q", f, e.Err, want)
+		}
+	}
+}
+
+func TestCacheOff(t *testing.T) {
+	dir := t.TempDir()
+	slot := "slot test daily 8:00AM 1h\n"
+	c := testConfig(t, dir, slot+"cache-size 1G\n")
+	tk := testLibrary(t, c, map[string]*MediaInfo{"1.mkv": episode(time.Minute)})
+	if tk.cache == nil || tk.cache.max != 1<<30 {
+		t.Fatalf("cache %+v, want 1G", tk.cache)
+	}
+
+	writeConfig(t, dir, slot)
+	if err := c.Update(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tk.Update(context.Background(), c); err != nil {
+		t.Fatal(err)
+	}
+	if tk.cache != nil {
+		t.Errorf("the cache is still on once its size is 0")
+	}
+}
+
+func TestRecord(t *testing.T) {
+	dir := t.TempDir()
+	c := testConfig(t, dir, "slot test daily 8:00AM 30m\norder series\n")
+	infos := make(map[string]*MediaInfo)
+	for i := 1; i <= 9; i++ {
+		infos[fmt.Sprintf("s/%v.mkv", i)] = episode(10 * time.Minute)
+	}
+	tk := testLibrary(t, c, infos)
+	old := defaultTank
+	defaultTank = tk
+	t.Cleanup(func() { defaultTank = old })
+	names := func(p *Program) string {
+		var s []string
+		for _, c := range p.cs {
+			s = append(s, filepath.Base(c.filename))
+		}
+		return strings.Join(s, " ")
+	}
+
+	ws := c.Windows(time.Now(), 2)
+	p, err := MakeProgramFor(c, ws[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(p); got != "1.mkv 2.mkv 3.mkv" {
+		t.Fatalf("program %v", got)
+	}
+	at := ws[0].Start
+	Airs(p, at)
+	next, err := Record(p, at.Add(5*time.Minute))
+	if err != nil || !next.Equal(at.Add(10*time.Minute)) {
+		t.Errorf("Record = %v, %v; want %v, nil", next, err, at.Add(10*time.Minute))
+	}
+	if _, err = Record(p, at.Add(15*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the next program follows the one on the air
+	n, err := MakeProgramAfter(c, ws[1], p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(n); got != "4.mkv 5.mkv 6.mkv" {
+		t.Errorf("next program %v, want 4.mkv 5.mkv 6.mkv", got)
+	}
+	// but only the first episode is aired for now
+	h := LoadHistory(c.HistoryFile())
+	if len(h.as) != 1 || filepath.Base(h.as[0].File) != "1.mkv" || !h.as[0].Time.Equal(at) {
+		t.Errorf("history %+v, want 1.mkv at %v", h.as, at)
+	}
+	if st := LoadState(c.StateFile()); filepath.Base(st.Series[filepath.Join(c.DataDir(), "s")]) != "1.mkv" {
+		t.Errorf("state %+v, want 1.mkv", st.Series)
+	}
+	if p, err = MakeProgramFor(c, ws[0]); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(p); got != "2.mkv 3.mkv 4.mkv" {
+		t.Errorf("program after a crash %v, want 2.mkv 3.mkv 4.mkv", got)
+	}
+}
+
+func TestCheckDirConfigs(t *testing.T) {
+	dir := t.TempDir()
+	c := testConfig(t, dir, "slot test daily 8:00AM 1h\nfiller data/filler\nignore **/extras\n")
+	for f, conf := range map[string]string{
+		".conf":           "skip bad\n",
+		"extras/.conf":    "unknown\n",
+		"sub/bad/.conf":   "unknown\n",
+		"sub/good/.conf":  "unknown\n",
+		"filler/.conf":    "unknown\n",
+		"filler/ok/.conf": "order sequential\n",
+	} {
+		f = filepath.Join(c.DataDir(), f)
+		if err := os.MkdirAll(filepath.Dir(f), 0775); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(f, []byte(conf), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	errs := CheckDirConfigs(c)
+	var files []string
+	for _, e := range errs {
+		var ce *config.Error
+		if !errors.As(e, &ce) {
+			t.Fatalf("%v is not a config.Error", e)
+		}
+		rel, _ := filepath.Rel(c.DataDir(), ce.File)
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+	if got := strings.Join(files, " "); got != "filler/.conf sub/good/.conf" {
+		t.Errorf("errors in %v, want filler/.conf sub/good/.conf", got)
+	}
+}
+
+func TestDirConfigErrors(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		line string
+		col  int
+		err  string
+	}{
+		{"unknown 1", 1, "unknown directive 'unknown'"},
+		{"  weight", 3, "'weight' lacks argument"},
+		{"video x", 7, "invalid stream 'x'"},
+		{"video 0 1", 9, "only one video stream can be used"},
+		{"audio lang=jpn  fallback=maybe", 17, "unknown fallback 'maybe'"},
+		{"subtitles -1", 11, "invalid stream '-1'"},
+		{"order  random", 8, "unknown order 'random'"},
+		{"weight 0", 8, "invalid weight '0'"},
+		{"skip a re:(", 8, "missing closing )"},
+		{`skip 'a`, 6, "unterminated quote"},
+	} {
+		f := filepath.Join(dir, ".conf")
+		if err := os.WriteFile(f, []byte("order shuffle\n"+tt.line+"\n"), 0666); err != nil {
+			t.Fatal(err)
+		}
+		_, errs := readConfig(f, &dirConfig{})
+		if len(errs) != 1 {
+			t.Errorf("%q: errors %v, want one", tt.line, errs)
+			continue
+		}
+		e := errs[0]
+		if e.Line != 2 || e.Col != tt.col || !strings.Contains(e.Err.Error(), tt.err) {
+			t.Errorf("%q: error %v, want .conf:2:%v: %v", tt.line, e, tt.col, tt.err)
+		}
+	}
+}
+
+func TestRecordCut(t *testing.T) {
+	dir := t.TempDir()
+	c := testConfig(t, dir, "slot test daily 8:00AM 30m\norder series\n")
+	tk := testLibrary(t, c, map[string]*MediaInfo{
+		"s/1.mkv": episode(45 * time.Minute),
+		"s/2.mkv": episode(45 * time.Minute),
+	})
+	w := c.NextWindow(time.Now())
+	p, err := tk.program(c, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.cs) != 1 || p.cs[0].trim != 30*time.Minute {
+		t.Fatalf("program %v, want 1.mkv cut to 30m", p.cs)
+	}
+	if err = Aired(p, w.Start); err != nil {
+		t.Fatal(err)
+	}
+	if h := LoadHistory(c.HistoryFile()); len(h.as) != 0 {
+		t.Errorf("history %+v, want nothing aired", h.as)
+	}
+	if p, err = tk.program(c, w); err != nil {
+		t.Fatal(err)
+	}
+	if f := filepath.Base(p.cs[0].filename); f != "1.mkv" {
+		t.Errorf("the next program starts with %v, want 1.mkv again", f)
+	}
+}
+
+func TestOutdated(t *testing.T) {
+	dir := t.TempDir()
+	c := testConfig(t, dir, "slot test daily 8:00AM 1h\n")
+	tk := testLibrary(t, c, map[string]*MediaInfo{
+		"a/1.mkv": episode(20 * time.Minute),
+		"b/1.mkv": episode(20 * time.Minute),
+		"c/1.mkv": episode(20 * time.Minute),
+	})
+	old := defaultTank
+	defaultTank = tk
+	t.Cleanup(func() { defaultTank = old })
+	p, err := tk.program(c, c.NextWindow(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.cs) != 3 {
+		t.Fatalf("program %v, want the 3 files", p.cs)
+	}
+	update := func() {
+		t.Helper()
+		if err := tk.Update(context.Background(), c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	update()
+	if Outdated(p) {
+		t.Errorf("the program is outdated by a rescan")
+	}
+	// a new file does not change the program
+	if err := os.WriteFile(filepath.Join(c.DataDir(), "d.mkv"), nil, 0666); err != nil {
+		t.Fatal(err)
+	}
+	update()
+	if Outdated(p) {
+		t.Errorf("the program is outdated by a new file")
+	}
+	if err := os.WriteFile(filepath.Join(c.DataDir(), "a", ".conf"), []byte("video 2\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	update()
+	if !Outdated(p) {
+		t.Errorf("the program is not outdated by the .conf of a file")
+	}
+	os.Remove(filepath.Join(c.DataDir(), "a", ".conf"))
+	update()
+	if Outdated(p) {
+		t.Errorf("the program is outdated once the .conf is removed")
+	}
+	if err := os.Remove(filepath.Join(c.DataDir(), "b", "1.mkv")); err != nil {
+		t.Fatal(err)
+	}
+	update()
+	if !Outdated(p) {
+		t.Errorf("the program is not outdated by the removal of a file")
+	}
+}
@@ -0,0 +1,83 @@
+package hls
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/vonaka/smc_station/config"
+)
+
+// Entry is an item of the timeline of a program.
+type Entry struct {
+	File     string
+	Kind     string // episode, filler, ident, bumper, signoff or slate
+	Series   string
+	Title    string
+	Offset   time.Duration // from the start of the program
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Window returns the broadcast window of p.
+func (p *Program) Window() config.Window {
+	return p.window
+}
+
+// Timeline returns what p airs and when, it is known before p is written.
+// The times are relative to the start of the window until p airs. The
+// slates aired while the transcoding was late delay what follows them.
+func (p *Program) Timeline() []Entry {
+	p.mu.Lock()
+	start := p.start
+	slates := make(map[int]time.Duration, len(p.slates))
+	for i, d := range p.slates {
+		slates[i] = d
+	}
+	p.mu.Unlock()
+	if start.IsZero() {
+		start = p.window.Start
+	}
+	es := make([]Entry, 0, len(p.cs))
+	offset := time.Duration(0)
+	for i, c := range p.cs {
+		if d, ok := slates[i]; ok {
+			es = append(es, Entry{
+				Kind:     "slate",
+				Offset:   offset,
+				Start:    start.Add(offset),
+				Duration: d,
+			})
+			offset += d
+		}
+		e := Entry{
+			File:     c.filename,
+			Kind:     c.kind,
+			Series:   c.series,
+			Offset:   offset,
+			Start:    start.Add(offset),
+			Duration: c.length(),
+		}
+		if e.Kind == "" {
+			e.Kind = "episode"
+		}
+		if c.info != nil {
+			e.Title = c.info.Tags.Title
+		}
+		es = append(es, e)
+		offset += e.Duration
+	}
+	return es
+}
+
+// MarshalJSON encodes the durations of e in seconds.
+func (e Entry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		File     string    `json:"file"`
+		Kind     string    `json:"kind"`
+		Series   string    `json:"series,omitempty"`
+		Title    string    `json:"title,omitempty"`
+		Offset   float64   `json:"offset"`
+		Start    time.Time `json:"start"`
+		Duration float64   `json:"duration"`
+	}{e.File, e.Kind, e.Series, e.Title, e.Offset.Seconds(), e.Start, e.Duration.Seconds()})
+}
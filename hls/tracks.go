@@ -0,0 +1,170 @@
+package hls
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// trackRule selects audio or subtitle streams, either by their index
+// or by language. When none of the languages is available the fallback
+// applies: `default` picks the stream flagged as default (or the first
+// one), `first` the first stream and `none` no stream at all.
+type trackRule struct {
+	indexes  []int
+	langs    []string
+	fallback string
+}
+
+// language codes that may be used for the same language
+var langAliases = map[string][]string{
+	"en": {"eng"}, "eng": {"en"},
+	"ja": {"jpn"}, "jpn": {"ja"},
+	"fr": {"fre", "fra"}, "fre": {"fr", "fra"}, "fra": {"fr", "fre"},
+	"de": {"ger", "deu"}, "ger": {"de", "deu"}, "deu": {"de", "ger"},
+	"es": {"spa"}, "spa": {"es"},
+	"it": {"ita"}, "ita": {"it"},
+	"ru": {"rus"}, "rus": {"ru"},
+	"zh": {"chi", "zho"}, "chi": {"zh", "zho"}, "zho": {"zh", "chi"},
+	"ko": {"kor"}, "kor": {"ko"},
+	"pt": {"por"}, "por": {"pt"},
+}
+
+// parseTrackRule parses `0 1` or `lang=jpn,eng [fallback=default]`.
+func parseTrackRule(args []string, fallback string) (*trackRule, error) {
+	r := &trackRule{fallback: fallback}
+	for _, a := range args {
+		if err := r.parse(a); err != nil {
+			return nil, err
+		}
+	}
+	return r, r.check()
+}
+
+// parse adds the argument a to r.
+func (r *trackRule) parse(a string) error {
+	switch {
+	case strings.HasPrefix(a, "lang="):
+		for _, l := range strings.Split(a[len("lang="):], ",") {
+			if l != "" {
+				r.langs = append(r.langs, strings.ToLower(l))
+			}
+		}
+	case strings.HasPrefix(a, "fallback="):
+		switch f := a[len("fallback="):]; f {
+		case "default", "first", "none":
+			r.fallback = f
+		default:
+			return fmt.Errorf("unknown fallback '%v'", f)
+		}
+	default:
+		i, err := strconv.Atoi(a)
+		if err != nil || i < 0 {
+			return fmt.Errorf("invalid stream '%v'", a)
+		}
+		r.indexes = append(r.indexes, i)
+	}
+	return nil
+}
+
+// check reports whether r selects any stream.
+func (r *trackRule) check() error {
+	if len(r.indexes) == 0 && len(r.langs) == 0 {
+		return fmt.Errorf("no stream selected")
+	}
+	return nil
+}
+
+func sameLang(a, b string) bool {
+	a, b = strings.ToLower(a), strings.ToLower(b)
+	if a == b {
+		return true
+	}
+	for _, l := range langAliases[a] {
+		if l == b {
+			return true
+		}
+	}
+	return false
+}
+
+// audio returns the audio streams of info selected by r: one stream
+// per language, in the order of preference. A stream selected twice is
+// kept once.
+func (r *trackRule) audio(info *MediaInfo) []int {
+	var as []int
+	seen := make(map[int]bool)
+	add := func(i int) {
+		if !seen[i] {
+			seen[i] = true
+			as = append(as, i)
+		}
+	}
+	for _, i := range r.indexes {
+		if i < len(info.Audio) {
+			add(i)
+		}
+	}
+	for _, l := range r.langs {
+		for _, a := range info.Audio {
+			if sameLang(a.Language, l) {
+				add(a.Stream)
+				break
+			}
+		}
+	}
+	if len(as) > 0 || len(info.Audio) == 0 {
+		return as
+	}
+	switch r.fallback {
+	case "none":
+		return nil
+	case "default":
+		for _, a := range info.Audio {
+			if a.Default {
+				return []int{a.Stream}
+			}
+		}
+	}
+	return []int{0}
+}
+
+// subtitle returns the subtitle stream of info selected by r, or -1.
+func (r *trackRule) subtitle(info *MediaInfo) int {
+	if r == nil || len(info.Subtitles) == 0 {
+		return -1
+	}
+	for _, i := range r.indexes {
+		if i < len(info.Subtitles) {
+			return i
+		}
+	}
+	for _, l := range r.langs {
+		for _, s := range info.Subtitles {
+			if sameLang(s.Language, l) {
+				return s.Stream
+			}
+		}
+	}
+	switch r.fallback {
+	case "first":
+		return 0
+	case "default":
+		for _, s := range info.Subtitles {
+			if s.Default {
+				return s.Stream
+			}
+		}
+		return 0
+	}
+	return -1
+}
+
+// bitmap tells whether the subtitle stream is made of images.
+func (s SubtitleStream) bitmap() bool {
+	switch s.Codec {
+	case "hdmv_pgs_subtitle", "dvd_subtitle", "dvb_subtitle", "xsub":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package hls
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestAudioTracks(t *testing.T) {
+	info := &MediaInfo{Audio: []AudioStream{
+		{Stream: 0, Language: "jpn"},
+		{Stream: 1, Language: "eng"},
+	}}
+	r, err := parseTrackRule([]string{"0", "lang=jpn,eng"}, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if as := r.audio(info); !reflect.DeepEqual(as, []int{0, 1}) {
+		t.Errorf("audio 0 lang=jpn,eng selects %v, want [0 1]", as)
+	}
+
+	// the program gets the tracks of its richest chunk
+	p := &Program{cs: []chunk{
+		{info: info, audiostream: []int{0, 1}, subtitle: -1, duration: time.Minute},
+		{info: episode(time.Minute), audiostream: []int{0}, subtitle: -1, duration: time.Minute},
+		{info: &MediaInfo{}, subtitle: -1, duration: time.Minute},
+	}}
+	alen := p.audioStreams()
+	if alen != 2 {
+		t.Fatalf("%v audio tracks, want 2", alen)
+	}
+	for i, silent := range []int{0, 1, 2} {
+		j := p.cs[i].job("out.m3u8", alen)
+		if len(j.Audio)+j.Silent != alen || j.Silent != silent {
+			t.Errorf("chunk %v: %v streams and %v silent, want %v silent",
+				i, len(j.Audio), j.Silent, silent)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Job describes the transcoding of a file into an HLS playlist. Without
+// Input, a black screen with Silent silent audio streams is made.
+type Job struct {
+	Input  string
+	Output string // the .m3u8 playlist, segments are written next to it
+
+	Video     int
+	CopyVideo bool
+	// Subtitle is the subtitle stream to burn in, -1 for none.
+	Subtitle       int
+	SubtitleBitmap bool
+	// Audio are the audio streams, Languages their languages (if known).
+	// Silent streams follow them, so that every part of a program has
+	// the same audio tracks.
+	Audio     []int
+	Languages []string
+	Silent    int
+
+	// Length is how long the output lasts, the input is cut if Trim.
+	Length time.Duration
+	Trim   bool
+
+	// Threads caps the threads of the encoder, 0 for automatic.
+	Threads int
+
+	// Progress, if set, is called with how much of the output is
+	// transcoded as the transcoding goes.
+	Progress func(done time.Duration)
+}
+
+// Transcoder runs transcoding jobs.
+type Transcoder interface {
+	Transcode(ctx context.Context, j *Job) error
+}
+
+var defaultTranscoder Transcoder = FFmpeg{}
+
+// SetTranscoder sets the transcoder used to write the programs.
+func SetTranscoder(t Transcoder) {
+	defaultTranscoder = t
+}
+
+// FFmpeg transcodes with the ffmpeg command, which is killed if the
+// context is done before it is over.
+type FFmpeg struct{}
+
+func (FFmpeg) Transcode(ctx context.Context, j *Job) error {
+	args := j.args()
+	log.Printf("hls: %v\n%v\n", j.Input, strings.Join(args, " "))
+	if j.Progress != nil {
+		args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	}
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	var out io.ReadCloser
+	if j.Progress != nil {
+		var err error
+		if out, err = cmd.StdoutPipe(); err != nil {
+			return err
+		}
+	}
+	err := cmd.Start()
+	if err == nil {
+		if out != nil {
+			readProgress(out, j.Progress)
+		}
+		err = cmd.Wait()
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("ffmpeg: %v: %v", j.Input, msg)
+		}
+		return fmt.Errorf("ffmpeg: %v: %v", j.Input, err)
+	}
+	return nil
+}
+
+// readProgress reads the key=value lines of `ffmpeg -progress` from r
+// and calls f with the transcoded time.
+func readProgress(r io.Reader, f func(time.Duration)) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), "=", 2)
+		// out_time_ms is in microseconds too
+		if len(kv) != 2 || (kv[0] != "out_time_us" && kv[0] != "out_time_ms") {
+			continue
+		}
+		if us, err := strconv.ParseInt(kv[1], 10, 64); err == nil && us >= 0 {
+			f(time.Duration(us) * time.Microsecond)
+		}
+	}
+}
+
+// args returns the arguments of ffmpeg for j.
+func (j *Job) args() []string {
+	if j.Input == "" {
+		return j.blankArgs()
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", j.Input}
+	if j.Silent > 0 {
+		args = append(args, "-f", "lavfi", "-i", silence)
+	}
+	if j.CopyVideo && j.Subtitle < 0 {
+		args = append(args, "-vcodec", "copy")
+	} else {
+		args = append(args, "-crf", "17", "-vcodec", "h264")
+	}
+	if j.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(j.Threads))
+	}
+	if j.Trim || j.Silent > 0 {
+		// the silence never ends
+		args = append(args, "-t", fmt.Sprintf("%.3f", j.Length.Seconds()))
+	}
+	// attached pictures are not mapped
+	switch {
+	case j.Subtitle < 0:
+		args = append(args, "-map", fmt.Sprintf("0:v:%v", j.Video))
+	case j.SubtitleBitmap:
+		// subtitles are burnt in
+		args = append(args, "-filter_complex",
+			fmt.Sprintf("[0:v:%v][0:s:%v]overlay[v]", j.Video, j.Subtitle),
+			"-map", "[v]")
+	default:
+		args = append(args, "-vf",
+			fmt.Sprintf("subtitles=f=%v:si=%v", filterEscape(j.Input), j.Subtitle),
+			"-map", fmt.Sprintf("0:v:%v", j.Video))
+	}
+	args = append(args, "-acodec", "aac")
+	// the streams are ordered by language preference
+	for i, a := range j.Audio {
+		args = append(args, "-map", fmt.Sprintf("0:a:%v", a))
+		if i < len(j.Languages) && j.Languages[i] != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:a:%v", i), "language="+j.Languages[i])
+		}
+	}
+	for i := 0; i < j.Silent; i++ {
+		args = append(args, "-map", "1:a")
+	}
+	return j.hlsArgs(args)
+}
+
+// silence is the source of the silent audio streams.
+const silence = "anullsrc=r=48000:cl=stereo"
+
+// blankArgs returns the arguments of ffmpeg for a job without input.
+func (j *Job) blankArgs() []string {
+	args := []string{"-hide_banner", "-loglevel", "error",
+		"-f", "lavfi", "-i", "color=c=black:s=1280x720:r=25",
+		"-f", "lavfi", "-i", silence,
+		"-t", fmt.Sprintf("%.3f", j.Length.Seconds()),
+		"-crf", "17", "-vcodec", "h264"}
+	if j.Threads > 0 {
+		args = append(args, "-threads", strconv.Itoa(j.Threads))
+	}
+	args = append(args, "-map", "0:v", "-acodec", "aac")
+	for i := 0; i < j.Silent; i++ {
+		args = append(args, "-map", "1:a")
+	}
+	return j.hlsArgs(args)
+}
+
+// hlsArgs completes args with the HLS output of j.
+func (j *Job) hlsArgs(args []string) []string {
+	return append(args,
+		"-metadata", "service_name=program",
+		"-pix_fmt", "yuv420p",
+		"-f", "hls",
+		"-hls_list_size", "0",
+		"-hls_segment_type", "mpegts",
+		j.Output)
+}
+
+// FakeTranscoder records the jobs and writes playlists made of empty
+// segments of the expected length, without reading the inputs.
+type FakeTranscoder struct {
+	mu      sync.Mutex
+	Jobs    []Job
+	Segment time.Duration // 2s if zero
+	Err     error         // returned for every job, if set
+}
+
+func (f *FakeTranscoder) Transcode(ctx context.Context, j *Job) error {
+	f.mu.Lock()
+	f.Jobs = append(f.Jobs, *j)
+	seg, ferr := f.Segment, f.Err
+	f.mu.Unlock()
+	if ferr != nil {
+		return ferr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if seg <= 0 {
+		seg = 2 * time.Second
+	}
+
+	base := strings.TrimSuffix(j.Output, filepath.Ext(j.Output))
+	str := "#EXTM3U\n#EXT-X-VERSION:3\n"
+	str += fmt.Sprintf("#EXT-X-TARGETDURATION:%v\n", int((seg+time.Second-1)/time.Second))
+	str += "#EXT-X-MEDIA-SEQUENCE:0\n"
+	for i, left := 0, j.Length; left > 0; i++ {
+		d := seg
+		if left < d {
+			d = left
+		}
+		s := fmt.Sprintf("%v%v.ts", base, i)
+		if err := os.WriteFile(s, nil, 0666); err != nil {
+			return err
+		}
+		str += fmt.Sprintf("#EXTINF:%.6f,\n%v\n", d.Seconds(), filepath.Base(s))
+		left -= d
+	}
+	str += "#EXT-X-ENDLIST\n"
+	if j.Progress != nil {
+		j.Progress(j.Length)
+	}
+	return os.WriteFile(j.Output, []byte(str), 0666)
+}
@@ -0,0 +1,135 @@
+package hls
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// after returns the argument that follows the first flag of args.
+func after(args []string, flag string) (string, bool) {
+	for i := 0; i < len(args)-1; i++ {
+		if args[i] == flag {
+			return args[i+1], true
+		}
+	}
+	return "", false
+}
+
+func TestJobArgs(t *testing.T) {
+	input := `/data/it's "a" $HOME.mkv`
+	output := `/static/program/it's "a" $HOME.m3u8`
+	tests := []struct {
+		name  string
+		job   Job
+		flags map[string]string // expected values, "" for absent flags
+	}{{
+		name: "copy",
+		job:  Job{Video: 1, CopyVideo: true, Subtitle: -1, Audio: []int{2, 0}, Languages: []string{"jpn", ""}},
+		flags: map[string]string{
+			"-vcodec":         "copy",
+			"-map":            "0:v:1",
+			"-metadata:s:a:0": "language=jpn",
+			"-metadata:s:a:1": "",
+			"-t":              "",
+			"-filter_complex": "",
+			"-vf":             "",
+		},
+	}, {
+		name: "text subtitles",
+		job:  Job{CopyVideo: true, Subtitle: 1, Audio: []int{0}},
+		flags: map[string]string{
+			"-vcodec": "h264",
+			"-vf":     `subtitles=f=/data/it\\\'s "a" $HOME.mkv:si=1`,
+			"-map":    "0:v:0",
+		},
+	}, {
+		name: "bitmap subtitles",
+		job:  Job{CopyVideo: true, Subtitle: 2, SubtitleBitmap: true, Audio: []int{0}},
+		flags: map[string]string{
+			"-vcodec":         "h264",
+			"-filter_complex": "[0:v:0][0:s:2]overlay[v]",
+			"-map":            "[v]",
+			"-vf":             "",
+		},
+	}, {
+		name: "trim",
+		job:  Job{Subtitle: -1, Length: 90500 * time.Millisecond, Trim: true, Threads: 2},
+		flags: map[string]string{
+			"-t":       "90.500",
+			"-threads": "2",
+		},
+	}}
+	for _, test := range tests {
+		j := test.job
+		j.Input, j.Output = input, output
+		args := j.args()
+		// the arguments are not interpreted by a shell
+		if v, _ := after(args, "-i"); v != input {
+			t.Errorf("%v: input %q, want %q", test.name, v, input)
+		}
+		if args[len(args)-1] != output {
+			t.Errorf("%v: output %q, want %q", test.name, args[len(args)-1], output)
+		}
+		for flag, want := range test.flags {
+			v, ok := after(args, flag)
+			if want == "" && ok {
+				t.Errorf("%v: unexpected %v %q", test.name, flag, v)
+			} else if want != "" && v != want {
+				t.Errorf("%v: %v %q, want %q", test.name, flag, v, want)
+			}
+		}
+		var maps []string
+		for i := range args {
+			if args[i] == "-map" {
+				maps = append(maps, args[i+1])
+			}
+		}
+		if len(maps) != 1+len(j.Audio) {
+			t.Errorf("%v: maps %v", test.name, maps)
+		}
+	}
+}
+
+func TestJobArgsSilent(t *testing.T) {
+	j := Job{Input: "in.mkv", Output: "out.m3u8", Subtitle: -1, Audio: []int{0},
+		Silent: 2, Length: time.Minute}
+	str := strings.Join(j.args(), " ")
+	if !strings.Contains(str, "-i in.mkv -f lavfi -i "+silence+" ") {
+		t.Errorf("no silent input: %v", str)
+	}
+	if !strings.Contains(str, "-t 60.000") {
+		t.Errorf("the silent tracks are not cut: %v", str)
+	}
+	if !strings.Contains(str, "-map 0:a:0 -map 1:a -map 1:a ") {
+		t.Errorf("the silent tracks do not follow the audio streams: %v", str)
+	}
+
+	j = Job{Output: "slate.m3u8", Subtitle: -1, Silent: 2, Length: 10 * time.Second}
+	str = strings.Join(j.args(), " ")
+	if !strings.Contains(str, "-map 0:v -acodec aac -map 1:a -map 1:a ") {
+		t.Errorf("a black screen without its silent tracks: %v", str)
+	}
+}
+
+func TestJobVideo(t *testing.T) {
+	info := episode(time.Minute)
+	info.Video.Stream = 1 // after a cover
+	c := chunk{filename: "a.mkv", info: info, audiostream: []int{0}, subtitle: -1, duration: time.Minute}
+	if j := c.job("out.m3u8", 1); j.Video != 1 || !j.CopyVideo {
+		t.Errorf("video %v (copied: %v), want the probed stream 1 copied", j.Video, j.CopyVideo)
+	}
+	c.videostream = []int{1}
+	if j := c.job("out.m3u8", 1); j.Video != 1 || !j.CopyVideo {
+		t.Errorf("video %v (copied: %v), want 1 copied", j.Video, j.CopyVideo)
+	}
+	// the codec of the other streams is not known
+	c.videostream = []int{2}
+	j := c.job("out.m3u8", 1)
+	if j.Video != 2 || j.CopyVideo {
+		t.Errorf("video %v (copied: %v), want 2 transcoded", j.Video, j.CopyVideo)
+	}
+	if m, _ := after(j.args(), "-map"); m != "0:v:2" {
+		t.Errorf("-map %v, want 0:v:2", m)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vonaka/smc_station/config"
+	"github.com/vonaka/smc_station/hls"
+)
+
+type broadcast struct {
+	Slot    string      `json:"slot"`
+	Start   time.Time   `json:"start"`
+	End     time.Time   `json:"end"`
+	Entries []hls.Entry `json:"entries"`
+}
+
+// plan prints the next broadcasts, without transcoding anything.
+func plan(c *config.Config, args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	n := fs.Int("n", 3, "number of `broadcasts` to plan")
+	format := fs.String("format", "text", "output `format`: text or json")
+	cached := fs.Bool("cached", false, "read the library from the index, without probing new files")
+	fs.Parse(args)
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format '%v'", *format)
+	}
+
+	if err := loadDryLibrary(c, *cached); err != nil {
+		return err
+	}
+	ps, err := hls.Plan(c, c.Windows(time.Now(), *n))
+	if err != nil {
+		return err
+	}
+
+	bs := make([]broadcast, 0, len(ps))
+	for _, p := range ps {
+		w := p.Window()
+		bs = append(bs, broadcast{w.Slot, w.Start, w.End, p.Timeline()})
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(bs)
+	}
+
+	loc := c.Location()
+	for i, b := range bs {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%v  %v - %v (%v)\n", b.Slot,
+			b.Start.In(loc).Format("Mon 2006-01-02 15:04"),
+			b.End.In(loc).Format("15:04"), loc)
+		for _, e := range b.Entries {
+			fmt.Printf("  %v  %-8v %-10v %v\n", e.Start.In(loc).Format("15:04:05"),
+				e.Kind, e.Duration.Round(time.Second), relPath(c, e.File))
+		}
+	}
+	return nil
+}
+
+// relPath returns f relative to the data directory, if it is in it.
+func relPath(c *config.Config, f string) string {
+	r, err := filepath.Rel(c.DataDir(), f)
+	if err != nil || strings.HasPrefix(r, "..") {
+		return f
+	}
+	return r
+}
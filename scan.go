@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vonaka/smc_station/config"
+	"github.com/vonaka/smc_station/hls"
+)
+
+// scan prints what the scan of the library found, skipped files included.
+func scan(c *config.Config, args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	format := fs.String("format", "table", "output `format`: table or json")
+	cached := fs.Bool("cached", false, "read the library from the index, without probing new files")
+	fs.Parse(args)
+	if *format != "table" && *format != "json" {
+		return fmt.Errorf("unknown format '%v'", *format)
+	}
+
+	if err := loadDryLibrary(c, *cached); err != nil {
+		return err
+	}
+	rs := hls.Report()
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(rs)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "FILE\tKIND\tDURATION\tVIDEO\tAUDIO\tSUBTITLES\tSTATUS")
+	for _, r := range rs {
+		kind := r.Kind
+		if kind == "" {
+			kind = "episode"
+		}
+		status := "ok"
+		if r.Skipped != "" {
+			status = "skipped: " + r.Skipped
+		}
+		duration, video, audio, subs := "-", "-", "-", "-"
+		if i := r.Info; i != nil {
+			duration = i.Duration.Round(time.Second).String()
+			video = i.Video.Codec
+			if i.Video.Width > 0 {
+				video += fmt.Sprintf(" %vx%v", i.Video.Width, i.Video.Height)
+			}
+			var as []string
+			for _, a := range r.Audio {
+				if a < len(i.Audio) {
+					as = append(as, streamName(a, i.Audio[a].Codec, i.Audio[a].Language))
+				}
+			}
+			audio = strings.Join(as, ",")
+			if r.Subtitle >= 0 && r.Subtitle < len(i.Subtitles) {
+				s := i.Subtitles[r.Subtitle]
+				subs = streamName(r.Subtitle, s.Codec, s.Language)
+			}
+		}
+		fmt.Fprintf(w, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", relPath(c, r.File),
+			kind, duration, video, audio, subs, status)
+	}
+	return w.Flush()
+}
+
+func streamName(i int, codec, lang string) string {
+	if lang == "" {
+		return fmt.Sprintf("%v:%v", i, codec)
+	}
+	return fmt.Sprintf("%v:%v(%v)", i, codec, lang)
+}
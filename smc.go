@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"runtime"
+	"syscall"
 
 	"github.com/vonaka/smc_station/config"
 	"github.com/vonaka/smc_station/hls"
@@ -23,7 +26,13 @@ func main() {
 	conf := flag.String("config", "smc.conf", "smc configuration `file`")
 	logger := flag.String("log", "<stderr>", "logger destination `file`")
 	httpAddr := flag.String("http", ":8080", "web server `address`")
+	reindex := flag.Bool("reindex", false, "rebuild the media library index")
 
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(),
+			"usage: %v [flags] [plan|scan|validate [flags]]\n", filepath.Base(os.Args[0]))
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	if *home == "$HOME" {
@@ -41,17 +50,63 @@ func main() {
 	if !filepath.IsAbs(configFile) {
 		configFile = filepath.Join(*home, *conf)
 	}
-	c, err := config.Open(configFile)
-	check(err)
-	err = hls.InitializeDataTank(c)
+	if flag.Arg(0) == "validate" {
+		os.Exit(validate(configFile, flag.Args()[1:]))
+	}
+	open := config.Open
+	if flag.Arg(0) == "plan" || flag.Arg(0) == "scan" {
+		// dry runs do not create the config file
+		open = config.Load
+	}
+	c, err := open(configFile)
 	check(err)
+	if *reindex {
+		check(hls.RemoveIndex(c.IndexFile()))
+	}
+	switch flag.Arg(0) {
+	case "":
+	case "plan":
+		check(plan(c, flag.Args()[1:]))
+		return
+	case "scan":
+		check(scan(c, flag.Args()[1:]))
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+	check(loadLibrary(c))
 	station.Initialize(c)
 	station.Start()
+	go func() {
+		// ffmpeg is stopped before leaving
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		<-ctx.Done()
+		stop()
+		log.Println("shutting down")
+		station.Shutdown()
+		os.Exit(0)
+	}()
 	fmt.Println("starting server at", *httpAddr)
 	err = webserver.Serve(*httpAddr, c.StaticDir())
 	check(err)
 }
 
+// loadLibrary initializes the media library, the scan can be interrupted.
+func loadLibrary(c *config.Config) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return hls.InitializeDataTank(ctx, c)
+}
+
+// loadDryLibrary initializes the media library to report on it or to
+// plan programs, see hls.InitializeDryTank.
+func loadDryLibrary(c *config.Config, cached bool) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return hls.InitializeDryTank(ctx, c, cached)
+}
+
 func check(err error) {
 	if err != nil {
 		log.Fatal(err)
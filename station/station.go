@@ -1,18 +1,23 @@
 package station
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/vonaka/smc_station/config"
 	"github.com/vonaka/smc_station/hls"
 	"github.com/vonaka/smc_station/viewer"
+	"github.com/vonaka/smc_station/watcher"
 )
 
 type Station struct {
@@ -23,8 +28,30 @@ type Station struct {
 	shutdown  chan struct{}
 	newViewer chan *viewer.Viewer
 	sigs      chan os.Signal
+	update    chan string
+	watcher   *watcher.Watcher
+	watched   string
+	mu        sync.Mutex
+	program   *hls.Program // on the air
+	next      *hls.Program // being prepared
+	playlist  string       // of the last program aired
+	ctx       context.Context
+	stop      context.CancelFunc
+	prep      *preparation
+	stopped   chan struct{}
 }
 
+// preparation is the preparation of the next program.
+type preparation struct {
+	after  time.Time // the program is the first one after
+	cancel context.CancelFunc
+	done   chan struct{}
+	ready  chan<- *hls.Program
+}
+
+// the delay during which file changes are gathered before reloading
+const watchDelay = 3 * time.Second
+
 func New(c *config.Config) *Station {
 	s := &Station{
 		c:         c,
@@ -34,11 +61,80 @@ func New(c *config.Config) *Station {
 		shutdown:  make(chan struct{}, 1),
 		newViewer: make(chan *viewer.Viewer, 10),
 		sigs:      make(chan os.Signal, 1),
+		update:    make(chan string, 1),
+		stopped:   make(chan struct{}),
 	}
+	s.ctx, s.stop = context.WithCancel(context.Background())
 	signal.Notify(s.sigs, syscall.SIGUSR1)
+	go func() {
+		for range s.sigs {
+			s.update <- "received SIGUSR1"
+		}
+	}()
+	s.watch()
 	return s
 }
 
+// watch watches the config file and the data directory, changes are
+// reported to s.update once no new change happened for watchDelay.
+func (s *Station) watch() {
+	if s.watcher != nil {
+		s.watcher.Close()
+		s.watcher = nil
+	}
+	w, err := watcher.New()
+	if err != nil {
+		log.Println("not watching files:", err)
+		return
+	}
+	conf := s.c.Path()
+	data := s.c.DataDir()
+	if err := w.Add(filepath.Dir(conf), false); err != nil {
+		log.Println("not watching config file:", err)
+	}
+	if err := w.Add(data, true); err != nil {
+		log.Println("not watching data directory:", err)
+	}
+	s.watcher, s.watched = w, data
+
+	go func() {
+		var (
+			confChanged bool
+			changes     []string
+			delay       <-chan time.Time
+		)
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if e.Path == conf {
+					confChanged = true
+				} else if strings.HasPrefix(e.Path, data+string(filepath.Separator)) {
+					changes = append(changes, e.Path+" "+e.Op.String())
+				} else {
+					continue
+				}
+				delay = time.After(watchDelay)
+			case <-delay:
+				var why []string
+				if confChanged {
+					why = append(why, filepath.Base(conf)+" changed")
+				}
+				if n := len(changes); n > 3 {
+					why = append(why, strings.Join(changes[:3], ", "))
+					why = append(why, fmt.Sprintf("and %v other library changes", n-3))
+				} else if n > 0 {
+					why = append(why, strings.Join(changes, ", "))
+				}
+				s.update <- strings.Join(why, ", ")
+				confChanged, changes, delay = false, nil, nil
+			}
+		}
+	}()
+}
+
 func greetViewer(v *viewer.Viewer, wait bool, startTime *time.Time) {
 	if !wait {
 		v.Record(&viewer.Action{
@@ -52,48 +148,20 @@ func greetViewer(v *viewer.Viewer, wait bool, startTime *time.Time) {
 	}
 }
 
+// prepareMargin is how much earlier than estimated the preparation of
+// a program starts.
+const prepareMargin = 5 * time.Minute
+
 func (s *Station) Start() {
 	go func() {
-		ready := make(chan struct{}, 0)
-		var program *hls.Program
+		defer close(s.stopped)
+		ready := make(chan *hls.Program)
+		// closed once the program on the air is written
+		var written <-chan struct{}
+		s.prep = s.prepare(time.Now(), nil, ready)
 		for {
-			// create a new program
-			go func() {
-				fmt.Println("preparing a new program")
-				if program != nil {
-					if err := program.Next(); err == hls.ErrTankIndex {
-						program = nil
-					} else if err != nil {
-						log.Fatal(err)
-					}
-				}
-				if program == nil {
-					p, err := hls.MakeProgram(s.c)
-					if err != nil {
-						// TODO: maybe do something smarter with this err
-						log.Fatal(err)
-					}
-					program = p
-				}
-				f := filepath.Join(s.c.StaticDir(), "program")
-				if _, err := os.Stat(f); os.IsNotExist(err) {
-					if err = os.Mkdir(f, 0775); err != nil {
-						log.Fatal(err)
-					}
-				} else {
-					if err = cleanProgramDir(f); err != nil {
-						log.Fatal(err)
-					}
-				}
-				f = filepath.Join(f, "program.m3u8")
-				if err := program.Write(f); err != nil {
-					log.Fatal(err)
-				}
-				fmt.Println("the program is ready")
-				ready <- struct{}{}
-			}()
-
 			var (
+				program      *hls.Program
 				pdone        bool
 				sigUpd       bool = true
 				playDuration time.Duration
@@ -103,7 +171,7 @@ func (s *Station) Start() {
 				ok, waitDuration, pd := s.c.ReadyToPlay()
 				playDuration = pd
 				if !ok {
-					startTime := time.Now().Add(waitDuration)
+					startTime := time.Now().Add(waitDuration).In(s.c.Location())
 					wait := time.After(waitDuration)
 					for v := range s.vs {
 						greetViewer(v, true, &startTime)
@@ -117,10 +185,15 @@ func (s *Station) Start() {
 							greetViewer(v, true, &startTime)
 						case v := <-s.leave:
 							delete(s.vs, v)
-						case <-s.sigs:
+						case <-s.shutdown:
+							s.halt(written)
+							return
+						case w := <-s.update:
+							// the preparation restarts with the
+							// new configuration
 							sigUpd = true
+							s.updateConfig([]string{w})
 							if pdone {
-								s.updateConfig()
 								break loop
 							}
 						case <-wait:
@@ -128,13 +201,10 @@ func (s *Station) Start() {
 							if pdone {
 								break loop
 							}
-						case <-ready:
+						case program = <-ready:
+							written, s.prep = s.prep.wait(), nil
 							pdone = true
-							if sigUpd {
-								s.updateConfig()
-								break loop
-							}
-							if wdone {
+							if sigUpd || wdone {
 								break loop
 							}
 						}
@@ -146,20 +216,33 @@ func (s *Station) Start() {
 							s.vs[v] = struct{}{}
 						case v := <-s.leave:
 							delete(s.vs, v)
-						case <-s.sigs:
+						case <-s.shutdown:
+							s.halt(written)
+							return
+						case w := <-s.update:
 							sigUpd = true
-						case <-ready:
-							if sigUpd {
-								s.updateConfig()
-							}
+							s.updateConfig([]string{w})
+						case program = <-ready:
+							written, s.prep = s.prep.wait(), nil
 							pdone = true
 						}
 					}
 				}
 			}
-			err := s.play(playDuration)
+			s.mu.Lock()
+			s.program, s.next = program, nil
+			s.playlist = program.Playlist()
+			s.mu.Unlock()
+			hls.Airs(program, time.Now())
+			// the next program is prepared while this one airs
+			s.prep = s.prepare(program.Window().End, written, ready)
+			err := s.play(program, playDuration)
+			s.mu.Lock()
+			s.program = nil
+			s.mu.Unlock()
 			if err != nil {
 				if errors.Is(err, ErrShut) {
+					s.halt(written)
 					fmt.Println(err)
 					return
 				}
@@ -169,38 +252,187 @@ func (s *Station) Start() {
 	}()
 }
 
-func cleanProgramDir(dir string) error {
+// prepare makes the first program after `after` and, once prev is
+// closed, transcodes it in its own directory. The transcoding starts as
+// late as it can, according to the estimated preparation time. The
+// program is sent to ready once it can air.
+func (s *Station) prepare(after time.Time, prev <-chan struct{}, ready chan<- *hls.Program) *preparation {
+	ctx, cancel := context.WithCancel(s.ctx)
+	pr := &preparation{
+		after:  after,
+		cancel: cancel,
+		done:   make(chan struct{}),
+		ready:  ready,
+	}
+	go func() {
+		defer close(pr.done)
+		if prev != nil {
+			// the end of the previous program may still be
+			// transcoding
+			<-prev
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		w := s.c.NextWindow(after)
+		s.mu.Lock()
+		airing := s.program
+		s.mu.Unlock()
+		p, err := hls.MakeProgramAfter(s.c, w, airing)
+		if err != nil {
+			// TODO: maybe do something smarter with this err
+			log.Fatal(err)
+		}
+		s.mu.Lock()
+		s.next = p
+		s.mu.Unlock()
+
+		r, t := p.Estimate()
+		start := w.Start.Add(-r - prepareMargin)
+		if d := time.Until(start); d > 0 {
+			log.Printf("the next program needs about %v to be ready (%v to be transcoded), preparing it at %v",
+				r.Round(time.Second), t.Round(time.Second), start.Format(time.Kitchen))
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return
+			}
+		} else if left := time.Until(w.Start); r > left && left > 0 {
+			log.Printf("warning: the next program needs about %v to be ready, it starts in %v",
+				r.Round(time.Second), left.Round(time.Second))
+		}
+
+		fmt.Println("preparing a new program")
+		root := filepath.Join(s.c.StaticDir(), "program")
+		s.mu.Lock()
+		keep := filepath.Dir(s.playlist)
+		s.mu.Unlock()
+		if err = cleanProgramDir(root, keep); err != nil {
+			log.Fatal(err)
+		}
+		dir := filepath.Join(root, strconv.FormatInt(w.Start.Unix(), 10))
+		if err = os.MkdirAll(dir, 0775); err != nil {
+			log.Fatal(err)
+		}
+		f := filepath.Join(dir, "program.m3u8")
+		go func() {
+			select {
+			case <-p.Ready():
+			case <-ctx.Done():
+				return
+			}
+			if ctx.Err() == nil {
+				fmt.Println("the program is ready")
+				select {
+				case ready <- p:
+				case <-ctx.Done():
+				}
+			}
+		}()
+		if err := p.Write(ctx, f); err != nil {
+			if ctx.Err() != nil {
+				log.Println("the preparation of the program is cancelled")
+				return
+			}
+			log.Fatal(err)
+		}
+		fmt.Println("the program is transcoded")
+	}()
+	return pr
+}
+
+// wait returns a channel closed once pr is over, nil if there is no
+// preparation.
+func (pr *preparation) wait() <-chan struct{} {
+	if pr == nil {
+		return nil
+	}
+	return pr.done
+}
+
+// restart prepares the next program again, if it is not ready yet.
+func (s *Station) restart() {
+	pr := s.prep
+	if pr == nil {
+		return
+	}
+	log.Println("restarting the preparation of the next program")
+	pr.cancel()
+	s.prep = s.prepare(pr.after, pr.done, pr.ready)
+}
+
+// halt stops the preparations and the transcodings, and waits for them
+// to be over.
+func (s *Station) halt(written <-chan struct{}) {
+	s.stop()
+	if ch := s.prep.wait(); ch != nil {
+		// the next program may be ready and waiting for its window
+		<-ch
+	}
+	if written != nil {
+		<-written
+	}
+}
+
+// cleanProgramDir removes from dir the playlists and the segments of
+// the programs, but those of the directory keep.
+func cleanProgramDir(dir, keep string) error {
 	d, err := os.Open(dir)
-	if err != nil {
+	if os.IsNotExist(err) {
+		return os.Mkdir(dir, 0775)
+	} else if err != nil {
 		return err
 	}
 	defer d.Close()
 
-	fs, err := d.Readdirnames(-1)
+	fs, err := d.Readdir(-1)
 	if err != nil {
 		return err
 	}
 
 	for _, f := range fs {
-		if ext := filepath.Ext(f); ext == ".ts" || ext == ".m3u8" {
-			err = os.RemoveAll(filepath.Join(dir, f))
-			if err != nil {
-				return err
+		p := filepath.Join(dir, f.Name())
+		if f.IsDir() {
+			// the directory of a program
+			if _, err := strconv.ParseInt(f.Name(), 10, 64); err != nil || p == keep {
+				continue
 			}
+		} else if ext := filepath.Ext(f.Name()); ext != ".ts" && ext != ".m3u8" {
+			continue
+		}
+		if err = os.RemoveAll(p); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (s *Station) play(d time.Duration) error {
+// play airs p for d. Its episodes are recorded as aired as they end.
+func (s *Station) play(p *hls.Program, d time.Duration) error {
 	for v := range s.vs {
 		greetViewer(v, false, nil)
 	}
 
+	var ended <-chan time.Time
+	record := func() {
+		next, err := hls.Record(p, time.Now())
+		if err != nil {
+			log.Println(err)
+		}
+		ended = nil
+		if !next.IsZero() {
+			ended = time.After(time.Until(next))
+		}
+	}
+	defer record()
+	record()
+
 	wait := time.After(d)
 	s.clock.Reset()
 	for {
 		select {
+		case <-ended:
+			record()
 		case v := <-s.newViewer:
 			s.vs[v] = struct{}{}
 			greetViewer(v, false, nil)
@@ -211,19 +443,67 @@ func (s *Station) play(d time.Duration) error {
 		case <-wait:
 			// TODO: notify the viewers that the show is over
 			return nil
-		case <-s.sigs:
-			s.updateConfig()
+		case w := <-s.update:
+			s.updateConfig([]string{w})
 		}
 	}
-	return nil
+}
+
+// Timeline returns the timeline of the program that is on the air or,
+// between two broadcasts, of the next one once it is planned.
+func (s *Station) Timeline() (config.Window, []hls.Entry, bool) {
+	s.mu.Lock()
+	p := s.program
+	if p == nil {
+		p = s.next
+	}
+	s.mu.Unlock()
+	if p == nil {
+		return config.Window{}, nil, false
+	}
+	return p.Window(), p.Timeline(), true
+}
+
+// Playlist returns the playlist of the program on the air, or of the
+// last one aired.
+func (s *Station) Playlist() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.playlist
 }
 
 func (s *Station) Time() int {
 	return s.clock.Time()
 }
 
+// Shutdown stops the started station, once what is being transcoded is
+// stopped.
 func (s *Station) Shutdown() {
 	s.shutdown <- struct{}{}
+	<-s.stopped
+}
+
+// ProgramProgress is how far the transcoding of a program is.
+type ProgramProgress struct {
+	Window config.Window
+	hls.Progress
+}
+
+// Progress returns how far the transcoding of the program on the air
+// and of the next one, once it is planned, are. The end of the program
+// on the air may still be transcoding while the next one waits for its
+// preparation to start.
+func (s *Station) Progress() (onAir, next *ProgramProgress) {
+	s.mu.Lock()
+	p, n := s.program, s.next
+	s.mu.Unlock()
+	if p != nil {
+		onAir = &ProgramProgress{p.Window(), p.Progress()}
+	}
+	if n != nil {
+		next = &ProgramProgress{n.Window(), n.Progress()}
+	}
+	return onAir, next
 }
 
 func (s *Station) AddViewer(v *viewer.Viewer) {
@@ -234,14 +514,30 @@ func (s *Station) Leave(v *viewer.Viewer) {
 	s.leave <- v
 }
 
-func (s *Station) updateConfig() {
-	log.Println("updating configuration, `static` field will be ignored")
+func (s *Station) updateConfig(why []string) {
+	log.Printf("updating configuration (%v), `static` field will be ignored",
+		strings.Join(why, "; "))
+	old := s.c.String()
 	if err := s.c.Update(); err != nil {
-		log.Println(err)
+		log.Printf("invalid configuration, keeping the previous one:\n%v", err)
+		return
+	}
+	if s.c.DataDir() != s.watched {
+		s.watch()
 	}
-	if err := hls.InitializeDataTank(s.c); err != nil {
+	if err := hls.UpdateTank(s.ctx, s.c); err != nil {
 		log.Println(err)
 	}
+	s.mu.Lock()
+	next := s.next
+	s.mu.Unlock()
+	// a program that is not planned yet is made from the new library,
+	// the others only have to be made again if their files changed
+	if s.c.String() == old && (next == nil || !hls.Outdated(next)) {
+		log.Println("the next program is not affected by the library changes")
+		return
+	}
+	s.restart()
 }
 
 var (
@@ -283,3 +579,24 @@ func Time() int {
 	}
 	return 0
 }
+
+func Timeline() (config.Window, []hls.Entry, bool) {
+	if defaultStation != nil {
+		return defaultStation.Timeline()
+	}
+	return config.Window{}, nil, false
+}
+
+func Playlist() string {
+	if defaultStation != nil {
+		return defaultStation.Playlist()
+	}
+	return ""
+}
+
+func Progress() (onAir, next *ProgramProgress) {
+	if defaultStation != nil {
+		return defaultStation.Progress()
+	}
+	return nil, nil
+}
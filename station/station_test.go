@@ -0,0 +1,101 @@
+package station
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vonaka/smc_station/config"
+	"github.com/vonaka/smc_station/hls"
+)
+
+// library writes in dir a config whose only slot starts in a few
+// minutes and a library of n episodes that is already in the index.
+func library(t *testing.T, dir string, n int) *config.Config {
+	t.Helper()
+	data := filepath.Join(dir, "data")
+	for _, d := range []string{data, filepath.Join(dir, "static")} {
+		if err := os.MkdirAll(d, 0775); err != nil {
+			t.Fatal(err)
+		}
+	}
+	type entry struct {
+		Size    int64          `json:"size"`
+		ModTime time.Time      `json:"mtime"`
+		Info    *hls.MediaInfo `json:"info"`
+	}
+	es := make(map[string]entry)
+	for i := 0; i < n; i++ {
+		f := filepath.Join(data, fmt.Sprintf("episode%v.mkv", i))
+		if err := os.WriteFile(f, nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Stat(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		es[f] = entry{fi.Size(), fi.ModTime(), &hls.MediaInfo{
+			Duration: 10 * time.Minute,
+			Video:    hls.VideoStream{Codec: "h264", Width: 640, Height: 480},
+			Audio:    []hls.AudioStream{{Codec: "aac", Channels: 2, Default: true}},
+		}}
+	}
+	index := filepath.Join(dir, "smc.index")
+	str, err := json.Marshal(map[string]interface{}{"version": 2, "entries": es})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(index, str, 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(3 * time.Minute).Format(time.Kitchen)
+	conf := filepath.Join(dir, "smc.conf")
+	str = []byte(fmt.Sprintf("slot test daily %v 1h\ndata %v\nstatic %v\nindex %v\ncache-size 0\n",
+		start, config.Quote(data), config.Quote(filepath.Join(dir, "static")), config.Quote(index)))
+	if err = os.WriteFile(conf, str, 0666); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.Load(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = hls.InitializeCachedDataTank(c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestShutdownBeforeWindow(t *testing.T) {
+	c := library(t, t.TempDir(), 3)
+	hls.SetTranscoder(&hls.FakeTranscoder{})
+	s := New(c)
+	s.Start()
+
+	// the program is ready and waits for its window
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if _, next := s.Progress(); next != nil && next.Total > 0 && next.Done == next.Total {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the program is not transcoded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		s.Shutdown()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown blocks")
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os/exec"
+
+	"github.com/vonaka/smc_station/config"
+	"github.com/vonaka/smc_station/hls"
+)
+
+// validate checks the config file, the .conf files of the library and
+// the tools the station needs. It prints every problem and returns the
+// exit status.
+func validate(path string, args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ExitOnError)
+	fs.Parse(args)
+
+	var errs []error
+	c, err := config.Load(path)
+	if err != nil {
+		var l config.ErrorList
+		if !errors.As(err, &l) {
+			fmt.Println(err)
+			return 1
+		}
+		for _, e := range l {
+			errs = append(errs, e)
+		}
+	} else {
+		for _, e := range c.Check() {
+			errs = append(errs, e)
+		}
+		for _, e := range hls.CheckDirConfigs(c) {
+			errs = append(errs, e)
+		}
+	}
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, err := range errs {
+		fmt.Println(err)
+	}
+	if len(errs) > 0 {
+		fmt.Printf("%v problems found\n", len(errs))
+		return 1
+	}
+	fmt.Println(path, "is valid")
+	return 0
+}
@@ -0,0 +1,178 @@
+package watcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Watcher reports the changes made to the watched files and
+// directories through inotify.
+type Watcher struct {
+	fd     int
+	f      *os.File
+	mu     sync.Mutex
+	dirs   map[int32]string
+	rec    map[int32]bool
+	Events chan Event
+}
+
+type Event struct {
+	Path string
+	Op   Op
+}
+
+type Op uint32
+
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+)
+
+const mask = syscall.IN_CREATE | syscall.IN_CLOSE_WRITE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+var ErrClosed error = errors.New("watcher is closed")
+
+func New() (*Watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		fd:     fd,
+		f:      os.NewFile(uintptr(fd), "inotify"),
+		dirs:   make(map[int32]string),
+		rec:    make(map[int32]bool),
+		Events: make(chan Event, 64),
+	}
+	go w.read()
+	return w, nil
+}
+
+// Add watches the directory dir, and all its subdirectories if
+// recursive is set. Directories created later are watched too.
+func (w *Watcher) Add(dir string, recursive bool) error {
+	if !recursive {
+		return w.add(dir, false)
+	}
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return w.add(path, true)
+		}
+		return nil
+	})
+}
+
+func (w *Watcher) add(dir string, recursive bool) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.dirs == nil {
+		return ErrClosed
+	}
+	wd, err := syscall.InotifyAddWatch(w.fd, dir, mask)
+	if err != nil {
+		return &os.PathError{Op: "inotify_add_watch", Path: dir, Err: err}
+	}
+	w.dirs[int32(wd)] = dir
+	w.rec[int32(wd)] = recursive
+	return nil
+}
+
+func (w *Watcher) Close() error {
+	w.mu.Lock()
+	if w.dirs == nil {
+		w.mu.Unlock()
+		return nil
+	}
+	w.dirs = nil
+	w.mu.Unlock()
+	return w.f.Close()
+}
+
+func (w *Watcher) read() {
+	defer close(w.Events)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.f.Read(buf)
+		if err != nil {
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			e := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			name := ""
+			if e.Len > 0 {
+				b := buf[off+syscall.SizeofInotifyEvent : off+syscall.SizeofInotifyEvent+int(e.Len)]
+				for i, c := range b {
+					if c == 0 {
+						b = b[:i]
+						break
+					}
+				}
+				name = string(b)
+			}
+			off += syscall.SizeofInotifyEvent + int(e.Len)
+			w.handle(e.Wd, e.Mask, name)
+		}
+	}
+}
+
+func (w *Watcher) handle(wd int32, m uint32, name string) {
+	w.mu.Lock()
+	if w.dirs == nil {
+		w.mu.Unlock()
+		return
+	}
+	dir, ok := w.dirs[wd]
+	rec := w.rec[wd]
+	if m&syscall.IN_IGNORED != 0 {
+		delete(w.dirs, wd)
+		delete(w.rec, wd)
+	}
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	path := filepath.Join(dir, name)
+	var op Op
+	switch {
+	case m&syscall.IN_CREATE != 0, m&syscall.IN_MOVED_TO != 0:
+		op = Create
+		if m&syscall.IN_ISDIR != 0 && rec {
+			w.Add(path, true)
+		}
+	case m&syscall.IN_CLOSE_WRITE != 0:
+		op = Write
+	case m&syscall.IN_DELETE != 0, m&syscall.IN_DELETE_SELF != 0:
+		op = Remove
+	case m&syscall.IN_MOVED_FROM != 0, m&syscall.IN_MOVE_SELF != 0:
+		op = Rename
+	default:
+		return
+	}
+	w.Events <- Event{path, op}
+}
+
+func (op Op) String() string {
+	switch op {
+	case Create:
+		return "created"
+	case Write:
+		return "modified"
+	case Remove:
+		return "removed"
+	case Rename:
+		return "renamed"
+	}
+	return "changed"
+}
@@ -0,0 +1,42 @@
+//go:build !linux
+// +build !linux
+
+package watcher
+
+import "errors"
+
+type Watcher struct {
+	Events chan Event
+}
+
+type Event struct {
+	Path string
+	Op   Op
+}
+
+type Op uint32
+
+const (
+	Create Op = 1 << iota
+	Write
+	Remove
+	Rename
+)
+
+var ErrUnsupported error = errors.New("file watching is not supported")
+
+func New() (*Watcher, error) {
+	return nil, ErrUnsupported
+}
+
+func (w *Watcher) Add(dir string, recursive bool) error {
+	return ErrUnsupported
+}
+
+func (w *Watcher) Close() error {
+	return nil
+}
+
+func (op Op) String() string {
+	return "changed"
+}
@@ -1,6 +1,7 @@
 package webserver
 
 import (
+	"encoding/json"
 	"log"
 	"net/http"
 	"path/filepath"
@@ -29,9 +30,14 @@ var (
 func (f fileWrapper) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if strings.HasPrefix(filepath.Base(r.URL.String()), "now.m3u8?version=") {
 		serverLock.Lock()
-		src := filepath.Join(f.staticDir, "program.m3u8")
+		src := station.Playlist()
 		dst := filepath.Join(f.staticDir, "now.m3u8")
 		time := station.Time()
+		if src == "" {
+			serverLock.Unlock()
+			http.Error(w, "nothing on the air", http.StatusServiceUnavailable)
+			return
+		}
 		err := hls.CopyWithTime(src, dst, time)
 		if err != nil {
 			log.Fatal(err)
@@ -48,6 +54,8 @@ func Serve(address, staticRoot string) error {
 	}
 	http.Handle("/", wrapper)
 	http.HandleFunc("/ws", wsHandler)
+	http.HandleFunc("/schedule", scheduleHandler)
+	http.HandleFunc("/progress", progressHandler)
 
 	s := &http.Server{
 		Addr:              address,
@@ -82,3 +90,53 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}()
 }
+
+// scheduleHandler serves the timeline of the current (or next) program.
+func scheduleHandler(w http.ResponseWriter, r *http.Request) {
+	win, es, ok := station.Timeline()
+	if !ok {
+		http.Error(w, "no program yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		Slot    string      `json:"slot"`
+		Start   time.Time   `json:"start"`
+		End     time.Time   `json:"end"`
+		Entries []hls.Entry `json:"entries"`
+	}{win.Slot, win.Start, win.End, es})
+	if err != nil {
+		log.Printf("schedule: %v", err)
+	}
+}
+
+type programProgress struct {
+	Slot  string    `json:"slot"`
+	Start time.Time `json:"start"`
+	hls.Progress
+}
+
+func newProgramProgress(p *station.ProgramProgress) *programProgress {
+	if p == nil {
+		return nil
+	}
+	return &programProgress{p.Window.Slot, p.Window.Start, p.Progress}
+}
+
+// progressHandler serves how far the transcoding of the program on the
+// air and of the next one is.
+func progressHandler(w http.ResponseWriter, r *http.Request) {
+	onAir, next := station.Progress()
+	if onAir == nil && next == nil {
+		http.Error(w, "no program yet", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(struct {
+		OnAir *programProgress `json:"on_air,omitempty"`
+		Next  *programProgress `json:"next,omitempty"`
+	}{newProgramProgress(onAir), newProgramProgress(next)})
+	if err != nil {
+		log.Printf("progress: %v", err)
+	}
+}